@@ -1,55 +1,156 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/agilistikmal/live-recorder/pkg/downloader"
+	"github.com/agilistikmal/live-recorder/pkg/naming"
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
 	"github.com/agilistikmal/live-recorder/pkg/recorder/live"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/tiktok"
 	"github.com/agilistikmal/live-recorder/pkg/recorder/watch"
 	"github.com/agilistikmal/live-recorder/utils"
 	"github.com/sirupsen/logrus"
 )
 
+// finalizeTimeout is how long recordings get to finalize their files after their ffmpeg processes were killed
+const finalizeTimeout = 30 * time.Second
+
+// defaultStatePath is where watch mode keeps its recordings across restarts
+const defaultStatePath = "./tmp/state.jsonl"
+
 func main() {
 	logrus.SetLevel(logrus.DebugLevel)
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		runRecover(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistory(os.Args[2:])
+		return
+	}
+
 	watchMode := flag.Bool("watch", false, "Watch for new lives")
 
-	platforms := flag.String("p", "", "Platforms to record (showroom,idn)")
-	query := flag.String("q", "", "Query to search for lives (streamer_username:*_JKT48,48_*;title:*JKT48*)")
-	url := flag.String("url", "", "URL to record (https://www.tiktok.com/@user/live)")
+	platforms := flag.String("p", "", fmt.Sprintf("Platforms to record (%s)", strings.Join(recorder.Platforms(), ",")))
+	query := flag.String("q", "", "Query to search for lives (streamer_username:*_JKT48,48_*;title:*JKT48*) or a filter expression (streamer_username = *_JKT48 AND view_count > 100)")
+	tiktokUsers := flag.String("tiktok-users", "", "TikTok usernames to poll for lives in watch mode (user1,user2)")
+	tiktokConcurrency := flag.Int("tiktok-concurrency", tiktok.DefaultPollConcurrency, "How many TikTok accounts of -tiktok-users are checked at once")
+	tiktokInterval := flag.Duration("tiktok-interval", tiktok.DefaultPollInterval, "Minimum delay between two requests checking TikTok accounts of -tiktok-users")
+	url := flag.String("url", "", "URL to record, platform is detected from the URL (https://www.tiktok.com/@user/live)")
+	recoverOnStart := flag.Bool("recover", true, "Recover recordings left unfinished by a previous run before starting, also available as the recover subcommand")
+	shutdownTimeout := flag.Duration("shutdown-timeout", time.Minute, "How long to wait on stop for recordings to finalize their files, a second stop signal kills them")
+	format := flag.String("format", "", "Output format (mp4,fmp4,ts,mkv), every format stays playable while recording, mp4 is finalized into a regular MP4 at the end")
+	remuxMP4 := flag.Bool("remux-mp4", false, "Remux fmp4, ts and mkv recordings to a regular MP4 once the stream ends")
+	splitDuration := flag.Duration("split-duration", 0, "Start a new file every time a recording reaches this duration (30m), 0 disables it")
+	splitSize := flag.Int64("split-size", 0, "Start a new file every time a recording reaches this many megabytes, 0 disables it")
+	outputTemplate := flag.String("output", naming.DefaultTemplate, fmt.Sprintf("Output path template inside ./tmp, with the fields {%s}", strings.Join(naming.FieldNames(), "},{")))
+	var outputRules listFlag
+	flag.Var(&outputRules, "output-rule", "Output path template for a platform or a streamer on it, can be repeated (idn={platform}/{name}/{date}_{title}.mkv or showroom/username=...)")
+	timezone := flag.String("tz", "Local", "Timezone of the date and time fields of the output path (Asia/Jakarta)")
+	progressInterval := flag.Duration("progress-interval", time.Minute, "How often to log the progress of every recording in watch mode, 0 disables it")
+	maxRecordings := flag.Int("max-recordings", 0, "Record at most this many lives at the same time in watch mode, others are queued, 0 means no limit")
+	var platformLimits listFlag
+	flag.Var(&platformLimits, "platform-limit", "Record at most this many lives of a platform at the same time, can be repeated (idn=2)")
+	priority := flag.String("priority", "", "How queued lives are ranked, comma separated criteria tried in order (streamers,followers,views)")
+	priorityStreamers := flag.String("priority-streamers", "", "Streamers ranked first by the streamers criterion, in order (user1,showroom/user2)")
+	preempt := flag.Bool("preempt", false, "Stop the lowest ranked recording when a higher ranked live is queued, it resumes once a slot is free")
+	retries := flag.Int("retries", watch.DefaultMaxRetries, "How many times a failed recording is started again while the live is on, 0 disables it")
+	retryDelay := flag.Duration("retry-delay", watch.DefaultRetryDelay, "Wait before the first retry of a failed recording, doubled after every retry")
+	retryMaxDelay := flag.Duration("retry-max-delay", watch.DefaultRetryMaxDelay, "Longest wait between retries of a failed recording")
+	statePath := flag.String("state", defaultStatePath, "File keeping the recordings across restarts in watch mode, so a finished live is not recorded again, empty disables it")
+	downloaderName := flag.String("downloader", downloader.DefaultBackend, fmt.Sprintf("Download backend (%s), native and flv do not require ffmpeg", strings.Join(downloader.Backends(), ",")))
 
 	flag.Parse()
 
-	if *platforms == "" {
-		logrus.Fatalf("Platforms are required")
-	}
-
 	if *query == "" && *url == "" {
 		logrus.Fatalf("Query or URL is required")
 	}
 
+	d, err := downloader.New(*downloaderName)
+	if err != nil {
+		logrus.Fatalf("Failed to create downloader: %v", err)
+	}
+	if *format != "" || *remuxMP4 {
+		formatSetter, ok := d.(recorder.FormatSetter)
+		if !ok {
+			logrus.Fatalf("Downloader %s does not support choosing the output format", d.Name())
+		}
+		if err := formatSetter.SetFormat(*format, *remuxMP4); err != nil {
+			logrus.Fatalf("Failed to set output format: %v", err)
+		}
+	}
+
+	if *splitDuration > 0 || *splitSize > 0 {
+		splitter, ok := d.(recorder.Splitter)
+		if !ok {
+			logrus.Fatalf("Downloader %s does not support splitting recordings", d.Name())
+		}
+		splitter.SetSplit(*splitDuration, *splitSize*1024*1024)
+	}
+
+	namingConfig := naming.NewConfig()
+	if err := namingConfig.SetDefault(*outputTemplate); err != nil {
+		logrus.Fatalf("Failed to parse output template: %v", err)
+	}
+	for _, rule := range outputRules {
+		if err := namingConfig.ParseRule(rule); err != nil {
+			logrus.Fatalf("Failed to parse output rule: %v", err)
+		}
+	}
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		logrus.Fatalf("Failed to load timezone: %v", err)
+	}
+	sessionOptions := &session.Options{Location: location}
+
+	if *recoverOnStart {
+		recoverDir(context.Background(), "./tmp", nil)
+	}
+
 	if *url != "" {
-		liveRecorder := live.NewRecorder(&recorder.LiveQuery{
-			Platforms: strings.Split(*platforms, ","),
-		})
-		live, err := liveRecorder.GetLive(*url)
+		// Platform is detected from the URL, -p is optional here
+		urlQuery := &recorder.LiveQuery{}
+		if *platforms != "" {
+			urlQuery.Platforms = strings.Split(*platforms, ",")
+		}
+		liveRecorder := live.NewRecorder(urlQuery)
+		liveRecorder.SetDownloader(d)
+		// Ctrl+C stops the recording and keeps what was captured so far
+		ctx := shutdownContext()
+
+		live, err := liveRecorder.GetLiveContext(ctx, *url)
 		if err != nil {
 			logrus.Fatalf("Failed to get live: %v", err)
 		}
-		filename := fmt.Sprintf("./tmp/%s/%s.mp4", live.Platform, live.Streamer.Username)
-		liveRecorder.Record(live, filename)
-		logrus.Infof("Download completed: %v", filename)
+		// Recording resumes while the same live is still on after a drop
+		recordingSession := session.Record(ctx, liveRecorder, d, live, namingConfig.OutputPath("./tmp", live), sessionOptions)
+		if recordingSession.Result.Err != nil {
+			logrus.Fatalf("Failed to record live: %v", recordingSession.Result.Err)
+		}
+		for _, output := range recordingSession.Outputs {
+			logrus.Infof("Download completed: %v", output.OutputPath)
+		}
 		return
 	}
 
+	if *platforms == "" {
+		logrus.Fatalf("Platforms are required")
+	}
+
 	var liveQuery *recorder.LiveQuery
 	if *query != "" {
 		liveQuery = &recorder.LiveQuery{}
@@ -61,14 +162,63 @@ func main() {
 	}
 
 	liveRecorder := live.NewRecorder(liveQuery)
+	liveRecorder.SetDownloader(d)
+	if *tiktokUsers != "" {
+		tiktokRecorder, err := liveRecorder.Platform(recorder.PlatformTiktok)
+		if err != nil {
+			logrus.Fatalf("Failed to get tiktok recorder: %v", err)
+		}
+		if watchListRecorder, ok := tiktokRecorder.(recorder.WatchListRecorder); ok {
+			watchListRecorder.SetWatchList(strings.Split(*tiktokUsers, ","))
+		}
+		if pollLimiter, ok := tiktokRecorder.(recorder.PollLimiter); ok {
+			pollLimiter.SetPollLimits(*tiktokConcurrency, *tiktokInterval)
+		}
+	}
+
 	if *watchMode {
 		// Create buffered channels for events
 		liveChan := make(chan *recorder.Live, 100)        // Buffer 100 events
 		statusChan := make(chan *watch.StatusUpdate, 100) // Buffer 100 status updates
 
+		// Recordings restored from the store are older than this run
+		runStartedAt := time.Now()
 		watchService := watch.NewWatchLive(liveRecorder, "./tmp")
 		watchService.SetLiveChannel(liveChan)
 		watchService.SetStatusChannel(statusChan)
+		watchService.SetDownloader(d)
+		watchService.SetNaming(namingConfig)
+		watchService.SetSessionOptions(sessionOptions)
+		limits, err := parseLimits(*maxRecordings, platformLimits, *preempt)
+		if err != nil {
+			logrus.Fatalf("Failed to parse recording limits: %v", err)
+		}
+		watchService.SetLimits(limits)
+		priorityFunc, err := parsePriority(*priority, *priorityStreamers)
+		if err != nil {
+			logrus.Fatalf("Failed to parse priority: %v", err)
+		}
+		watchService.SetPriority(priorityFunc)
+		retryPolicy := &watch.RetryPolicy{MaxRetries: *retries, Delay: *retryDelay, MaxDelay: *retryMaxDelay}
+		if *retries <= 0 {
+			retryPolicy.MaxRetries = -1
+		}
+		watchService.SetRetryPolicy(retryPolicy)
+		if *statePath != "" {
+			store, err := watch.NewFileStore(*statePath)
+			if err != nil {
+				logrus.Fatalf("Failed to open state %s: %v", *statePath, err)
+			}
+			defer store.Close()
+			if err := watchService.SetStore(store); err != nil {
+				logrus.Fatalf("Failed to restore state %s: %v", *statePath, err)
+			}
+		}
+		if *progressInterval > 0 {
+			watchService.SetProgressInterval(*progressInterval)
+		} else {
+			watchService.SetProgressInterval(-1)
+		}
 
 		// Start goroutine to consume live events from channel
 		go func() {
@@ -77,7 +227,7 @@ func main() {
 				// Contoh: logging, notification, webhook, dll
 				logrus.WithFields(logrus.Fields{
 					"platform":      live.Platform,
-					"streamer":      live.Streamer.Username,
+					"streamer":      watch.NewRecordingKey(live).Streamer,
 					"title":         live.Title,
 					"view_count":    live.ViewCount,
 					"platform_url":  live.PlatformUrl,
@@ -89,6 +239,13 @@ func main() {
 		// Start goroutine to consume status updates from channel
 		go func() {
 			for update := range statusChan {
+				if update.Progress != nil {
+					logrus.Infof("Recording %s: %v captured, %.1f MB, %.0f kbps, %.2fx",
+						update.StreamerID, update.Progress.Elapsed.Round(time.Second), float64(update.Progress.Size)/1e6,
+						update.Progress.Bitrate/1000, update.Progress.Speed)
+					continue
+				}
+
 				// Process status updates here
 				// Contoh: logging, notification, webhook, database update, dll
 				logrus.WithFields(logrus.Fields{
@@ -98,8 +255,9 @@ func main() {
 					"title":        update.Info.Live.Title,
 					"started_at":   update.Info.StartedAt,
 					"completed_at": update.Info.CompletedAt,
-					"file_path":    update.Info.FilePath,
+					"file_paths":   update.Info.FilePaths(),
 					"file_size":    update.Info.FileSize,
+					"duration":     update.Info.Duration().String(),
 					"error":        update.Info.Error,
 				}).Info("Recording status update")
 
@@ -109,7 +267,9 @@ func main() {
 					logrus.Infof("Recording started for %s", update.StreamerID)
 				case watch.StatusCompleted:
 					logrus.Infof("Recording completed for %s: %s (Size: %d bytes)",
-						update.StreamerID, update.Info.FilePath, update.Info.FileSize)
+						update.StreamerID, strings.Join(update.Info.FilePaths(), ", "), update.Info.FileSize)
+				case watch.StatusRetrying:
+					logrus.Warnf("Recording failed for %s, retrying: %v", update.StreamerID, update.Info.Error)
 				case watch.StatusFailed:
 					logrus.Errorf("Recording failed for %s: %v", update.StreamerID, update.Info.Error)
 				}
@@ -117,32 +277,232 @@ func main() {
 		}()
 
 		// Start watch mode in goroutine so it doesn't block
-		go watchService.StartWatchMode()
+		ctx := shutdownContext()
+		watchDone := make(chan struct{})
+		go func() {
+			watchService.StartWatchModeContext(ctx)
+			close(watchDone)
+		}()
 
-		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		logrus.Info("Application is running in Watch Mode. Waiting for signal to stop...")
-		<-quit
+		<-watchDone
 
-		// Cleanup: close channels
-		close(liveChan)
-		close(statusChan)
+		// Cancelling ctx asked every ffmpeg to quit, give them time to finalize and join their files
+		waitCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		err = watchService.WaitContext(waitCtx)
+		cancel()
+		if err != nil {
+			logrus.Warnf("Recordings did not finish within %v, killed %d ffmpeg processes", *shutdownTimeout, utils.KillFFmpeg())
+
+			// Killed downloads still move their parts into place, which takes a moment
+			waitCtx, cancel := context.WithTimeout(context.Background(), finalizeTimeout)
+			err = watchService.WaitContext(waitCtx)
+			cancel()
+			if err != nil {
+				logrus.Warnf("Recordings did not finalize within %v after the kill, leftover parts are recovered on the next start", finalizeTimeout)
+			}
+		}
+		if err == nil {
+			// Cleanup: close channels, no recording sends to them anymore
+			close(liveChan)
+			close(statusChan)
+		}
 		logrus.Info("Received stop signal. Exiting.")
 
 		// Contoh: Print final status summary
-		allStatuses := watchService.GetAllStatuses()
-		logrus.Infof("Final status summary: %d recordings", len(allStatuses))
-		for streamerID, info := range allStatuses {
-			logrus.Infof("  %s: %s", streamerID, info.Status)
+		summary := finalSummary(watchService, runStartedAt)
+		logrus.Infof("Final status summary: %d recordings", len(summary))
+		for _, info := range summary {
+			logrus.Infof("  %s: %s %s", info.Key, info.Status, strings.Join(info.FilePaths(), ", "))
 		}
 	} else {
-		runOnce(liveRecorder, liveQuery)
+		runOnce(shutdownContext(), liveRecorder, d, namingConfig, sessionOptions)
+	}
+}
+
+// finalSummary returns the recordings of this run, finished ones first, then those still queued, running or retrying
+func finalSummary(watchService *watch.WatchLive, runStartedAt time.Time) []*watch.RecordingInfo {
+	var summary []*watch.RecordingInfo
+	for _, info := range watchService.GetHistory() {
+		// Skip the recordings restored from the state of a previous run
+		if !info.QueuedAt.Before(runStartedAt) {
+			summary = append(summary, info)
+		}
+	}
+
+	var unfinished []*watch.RecordingInfo
+	for _, info := range watchService.GetAllStatuses() {
+		if info.Status != watch.StatusCompleted && info.Status != watch.StatusFailed {
+			unfinished = append(unfinished, info)
+		}
+	}
+	sort.Slice(unfinished, func(i, j int) bool {
+		return unfinished[i].Key.String() < unfinished[j].Key.String()
+	})
+	return append(summary, unfinished...)
+}
+
+// parseLimits builds the recording limits from -max-recordings, -platform-limit and -preempt, nil when there are none
+func parseLimits(max int, platformLimits []string, preempt bool) (*watch.Limits, error) {
+	if max <= 0 && len(platformLimits) == 0 {
+		return nil, nil
 	}
+	limits := &watch.Limits{Max: max, PerPlatform: make(map[string]int), Preempt: preempt}
+	for _, platformLimit := range platformLimits {
+		platform, value, ok := strings.Cut(platformLimit, "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid platform limit %q, expected platform=number", platformLimit)
+		}
+		limits.PerPlatform[strings.ToLower(strings.TrimSpace(platform))] = limit
+	}
+	return limits, nil
+}
+
+// parsePriority builds the ranking of queued lives from -priority and -priority-streamers
+func parsePriority(criteria string, streamers string) (watch.Priority, error) {
+	var priorities []watch.Priority
+	for criterion := range strings.SplitSeq(criteria, ",") {
+		switch strings.TrimSpace(criterion) {
+		case "":
+		case "streamers":
+			priorities = append(priorities, watch.ByStreamers(strings.Split(streamers, ",")))
+		case "followers":
+			priorities = append(priorities, watch.ByFollowers)
+		case "views":
+			priorities = append(priorities, watch.ByViews)
+		default:
+			return nil, fmt.Errorf("unknown priority %q, expected streamers, followers or views", criterion)
+		}
+	}
+	if streamers != "" && !strings.Contains(criteria, "streamers") {
+		priorities = append([]watch.Priority{watch.ByStreamers(strings.Split(streamers, ","))}, priorities...)
+	}
+	if len(priorities) == 0 {
+		return nil, nil
+	}
+	return watch.Chain(priorities...), nil
+}
+
+// listFlag collects the values of a flag that can be repeated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
 }
 
-func runOnce(liveRecorder recorder.Recorder, liveQuery *recorder.LiveQuery) {
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runHistory handles the history subcommand, listing the recordings kept in the state file
+func runHistory(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	statePath := flags.String("state", defaultStatePath, "State file written by watch mode")
+	streamer := flags.String("streamer", "", "Streamer to list, a username or platform/username")
+	platform := flags.String("platform", "", "Platform to list")
+	status := flags.String("status", "", "Status to list (completed,failed,in_progress,queued,retrying)")
+	since := flags.Duration("since", 0, "Only list recordings started within this duration (720h for the last 30 days), 0 lists all")
+	limit := flags.Int("limit", 0, "List at most this many of the most recent recordings, 0 lists all")
+	flags.Parse(args)
+
+	// Read-only, watch mode may be recording to the same state
+	store, err := watch.LoadFileStore(*statePath)
+	if err != nil {
+		logrus.Fatalf("Failed to read state %s: %v", *statePath, err)
+	}
+
+	query := &watch.HistoryQuery{
+		Streamer: *streamer,
+		Platform: *platform,
+		Status:   watch.RecordingStatus(*status),
+		Limit:    *limit,
+	}
+	if *since > 0 {
+		query.Since = time.Now().Add(-*since)
+	}
+	records, err := store.Query(query)
+	if err != nil {
+		logrus.Fatalf("Failed to query state %s: %v", *statePath, err)
+	}
+
+	for _, record := range records {
+		info := record.Info()
+		logrus.WithFields(logrus.Fields{
+			"key":        record.Key.String(),
+			"status":     record.Status,
+			"title":      record.Live.Title,
+			"started_at": record.Time(),
+			"attempts":   len(record.Attempts),
+			"file_paths": info.FilePaths(),
+			"file_size":  info.FileSize,
+			"duration":   info.Duration().String(),
+			"error":      record.Error,
+		}).Info("Recording")
+	}
+	logrus.Infof("Found %d recordings", len(records))
+}
+
+// runRecover handles the recover subcommand
+func runRecover(args []string) {
+	flags := flag.NewFlagSet("recover", flag.ExitOnError)
+	dir := flags.String("dir", "./tmp", "Output directory to recover")
+	minAge := flags.Duration("min-age", session.DefaultRecoverMinAge, "Skip files modified more recently, they may still be recording")
+	sessionGap := flags.Duration("session-gap", session.DefaultRecoverSessionGap, "Longest gap between parts of the same streamer that are joined together")
+	dryRun := flags.Bool("dry-run", false, "Only report what would be recovered")
+	flags.Parse(args)
+
+	report := recoverDir(shutdownContext(), *dir, &session.RecoverOptions{
+		MinAge:     *minAge,
+		SessionGap: *sessionGap,
+		DryRun:     *dryRun,
+	})
+	if report == nil || len(report.Failed()) > 0 {
+		os.Exit(1)
+	}
+}
+
+// recoverDir runs a recovery pass over dir and logs what was recovered
+func recoverDir(ctx context.Context, dir string, opts *session.RecoverOptions) *session.RecoveryReport {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return &session.RecoveryReport{}
+	}
+
+	report, err := session.Recover(ctx, dir, opts)
+	if err != nil {
+		logrus.Errorf("Failed to recover %s: %v", dir, err)
+		return nil
+	}
+
+	// session.Recover logs every recording, only the summary is left here
+	logrus.Infof("Recovery of %s done: %d recordings, %d failed, %d leftover files removed",
+		dir, len(report.Sessions), len(report.Failed()), len(report.Removed))
+	return report
+}
+
+// shutdownContext returns a context cancelled on the first SIGINT or SIGTERM,
+// which lets running recordings finalize. A second signal kills them and exits.
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+		logrus.Info("Received stop signal, finalizing recordings. Send it again to force quit.")
+		cancel()
+
+		<-sigs
+		logrus.Warnf("Received second stop signal, killed %d ffmpeg processes", utils.KillFFmpeg())
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+func runOnce(ctx context.Context, liveRecorder recorder.Recorder, d recorder.Downloader, namingConfig *naming.Config, sessionOptions *session.Options) {
 	logrus.Info("Once mode started")
-	lives, err := liveRecorder.GetLives()
+	lives, err := liveRecorder.GetLivesContext(ctx)
 	if err != nil {
 		logrus.Errorf("Failed to get lives: %v", err)
 		return
@@ -158,7 +518,7 @@ func runOnce(liveRecorder recorder.Recorder, liveQuery *recorder.LiveQuery) {
 	wg := sync.WaitGroup{}
 	for _, live := range lives {
 		wg.Add(1)
-		streamingUrl, err := liveRecorder.GetStreamingUrl(live)
+		streamingUrl, quality, err := recorder.ResolveStreamingUrl(ctx, liveRecorder, live)
 		if err != nil {
 			logrus.Errorf("Failed to get streaming url: %v", err)
 			return
@@ -166,14 +526,29 @@ func runOnce(liveRecorder recorder.Recorder, liveQuery *recorder.LiveQuery) {
 
 		go func() {
 			defer wg.Done()
-			logrus.Infof("Recording started for %s", live.Streamer.Username)
+			key := watch.NewRecordingKey(live)
+			logrus.Infof("Recording started for %s", key)
 
-			filename := fmt.Sprintf("./tmp/%s/%s.mp4", live.Platform, live.Streamer.Username)
-			downloadInfo := utils.DownloadHLS(streamingUrl, &filename)
-			if downloadInfo == nil {
+			live.StreamingUrl, live.Quality = streamingUrl, quality
+			recordingSession := session.Record(ctx, liveRecorder, d, live, namingConfig.OutputPath("./tmp", live), sessionOptions)
+			downloadResult := recordingSession.Result
+			fields := logrus.Fields{
+				"url":          downloadResult.URL,
+				"output_path":  downloadResult.OutputPath,
+				"size":         downloadResult.Size,
+				"duration":     downloadResult.Duration.String(),
+				"segments":     downloadResult.Segments,
+				"parts":        len(recordingSession.Outputs),
+				"gaps":         recordingSession.Gaps().String(),
+				"exit_code":    downloadResult.ExitCode,
+				"started_at":   downloadResult.StartedAt,
+				"completed_at": downloadResult.CompletedAt,
+			}
+			if downloadResult.Err != nil {
+				logrus.WithFields(fields).Errorf("Download failed for %s: %v", key, downloadResult.Err)
 				return
 			}
-			logrus.WithFields(downloadInfo).Infof("Download completed for %s", live.Streamer.Username)
+			logrus.WithFields(fields).Infof("Download completed for %s", key)
 		}()
 	}
 	wg.Wait()
@@ -0,0 +1,73 @@
+// Package downloader provides the download backends used to save live streams.
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+)
+
+// Built-in backend names
+const (
+	BackendFFmpeg = "ffmpeg"
+	BackendNative = "native"
+	BackendFLV    = "flv"
+)
+
+// DefaultBackend is used when no backend is chosen
+const DefaultBackend = BackendFFmpeg
+
+// Factory creates a new Downloader
+type Factory func() recorder.Downloader
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Factory)
+)
+
+// Register makes a download backend available under the given name.
+// It panics if the factory is nil or the name is already registered.
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if factory == nil {
+		panic("downloader: Register factory is nil for backend " + name)
+	}
+	if _, exists := backends[name]; exists {
+		panic("downloader: Register called twice for backend " + name)
+	}
+	backends[name] = factory
+}
+
+// New creates a downloader for a registered backend
+func New(name string) (recorder.Downloader, error) {
+	backendsMu.RLock()
+	factory, exists := backends[name]
+	backendsMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("invalid downloader: %s", name)
+	}
+	return factory(), nil
+}
+
+// Default creates the default downloader
+func Default() recorder.Downloader {
+	return NewFFmpeg()
+}
+
+// Backends returns the sorted names of all registered backends
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/utils"
+)
+
+func init() {
+	Register(BackendFFmpeg, NewFFmpeg)
+}
+
+// FFmpegDownloader records streams with an external ffmpeg binary.
+// The output format follows the extension of the output path unless one is set with SetFormat.
+type FFmpegDownloader struct {
+	format        string
+	remuxMP4      bool
+	splitDuration time.Duration
+	splitSize     int64
+}
+
+func NewFFmpeg() recorder.Downloader {
+	return &FFmpegDownloader{}
+}
+
+func (d *FFmpegDownloader) Name() string {
+	return BackendFFmpeg
+}
+
+func (d *FFmpegDownloader) Capabilities() recorder.DownloaderCapabilities {
+	container := recorder.FormatMP4
+	if d.format != "" && !d.remuxMP4 {
+		container = strings.TrimPrefix(utils.FormatExt(d.format), ".")
+	}
+	return recorder.DownloaderCapabilities{
+		Protocols:      []string{recorder.ProtocolHLS, recorder.ProtocolFLV},
+		Container:      container,
+		RequiresFFmpeg: true,
+		CrashSafe:      true,
+	}
+}
+
+// SetFormat sets the output format for every recording, an empty format follows the output path
+func (d *FFmpegDownloader) SetFormat(format string, remuxMP4 bool) error {
+	if format != "" && !utils.ValidFormat(format) {
+		return fmt.Errorf("invalid output format: %s", format)
+	}
+	d.format = format
+	d.remuxMP4 = remuxMP4
+	return nil
+}
+
+// SetSplit makes ffmpeg stop at the given duration or size, the session then continues in a new file
+func (d *FFmpegDownloader) SetSplit(duration time.Duration, size int64) {
+	d.splitDuration = duration
+	d.splitSize = size
+}
+
+func (d *FFmpegDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	return utils.DownloadHLSWithOptions(ctx, url, &outputPath, &utils.DownloadOptions{
+		Format:        d.format,
+		RemuxMP4:      d.remuxMP4,
+		SplitDuration: d.splitDuration,
+		SplitSize:     d.splitSize,
+		Metadata:      utils.LiveMetadata(recorder.LiveFromContext(ctx)),
+	})
+}
@@ -0,0 +1,214 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(BackendFLV, NewFLV)
+}
+
+// FLVDownloader saves an HTTP-FLV stream byte for byte, without ffmpeg
+type FLVDownloader struct {
+	httpClient    *http.Client
+	splitDuration time.Duration
+	splitSize     int64
+}
+
+func NewFLV() recorder.Downloader {
+	return &FLVDownloader{
+		httpClient: &http.Client{},
+	}
+}
+
+func (d *FLVDownloader) Name() string {
+	return BackendFLV
+}
+
+func (d *FLVDownloader) Capabilities() recorder.DownloaderCapabilities {
+	return recorder.DownloaderCapabilities{
+		Protocols:      []string{recorder.ProtocolFLV},
+		Container:      "flv",
+		RequiresFFmpeg: false,
+		CrashSafe:      true,
+	}
+}
+
+// SetSplit ends a download once the stream reaches the given duration or size
+func (d *FLVDownloader) SetSplit(duration time.Duration, size int64) {
+	d.splitDuration = duration
+	d.splitSize = size
+}
+
+// Download writes the stream to outputPath with the .flv extension, appending a number to the name if that file exists
+func (d *FLVDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	result := &recorder.DownloadResult{
+		URL:       url,
+		StartedAt: time.Now(),
+		ExitCode:  -1,
+	}
+	fail := func(err error) *recorder.DownloadResult {
+		logrus.Errorf("Failed to download FLV: %v", err)
+		result.CompletedAt = time.Now()
+		result.Err = err
+		return result
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fail(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fail(err)
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("GET %s returned status %d", url, resp.StatusCode))
+	}
+
+	// Checked before creating the file, an HLS playlist or an error page is not saved as a recording
+	body := bufio.NewReader(resp.Body)
+	if signature, err := body.Peek(3); err != nil || string(signature) != "FLV" {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%w: %s is not an FLV stream", recorder.ErrUnsupportedProtocol, url)
+		}
+		return fail(err)
+	}
+
+	file, err := utils.CreateUnique(strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".flv")
+	if err != nil {
+		return fail(err)
+	}
+	defer file.Close()
+	outputPath = file.Name()
+	result.OutputPath = outputPath
+	recorder.ReportOutputPath(ctx, outputPath)
+
+	tags := &flvTagReader{}
+	limit := &flvSplitWriter{tags: tags, maxDuration: d.splitDuration, maxSize: d.splitSize}
+	progress := &flvProgressWriter{tags: tags, meter: recorder.NewProgressMeter(ctx, url, outputPath)}
+	result.Size, err = io.Copy(io.MultiWriter(file, tags, progress, limit), body)
+	result.Duration = tags.Duration()
+	if errors.Is(err, errSplit) {
+		result.Split = true
+		err = nil
+	}
+	if err != nil && (ctx.Err() == nil || result.Size == 0) {
+		return fail(err)
+	}
+	if err != nil {
+		logrus.Infof("Recording stopped: %v", ctx.Err())
+	}
+
+	result.ExitCode = 0
+	result.CompletedAt = time.Now()
+	return result
+}
+
+// errSplit ends the copy once a split limit is reached
+var errSplit = errors.New("split limit reached")
+
+// flvSplitWriter fails with errSplit once the stream written so far reaches a limit
+type flvSplitWriter struct {
+	tags        *flvTagReader
+	written     int64
+	maxDuration time.Duration
+	maxSize     int64
+}
+
+func (w *flvSplitWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if (w.maxSize > 0 && w.written >= w.maxSize) || (w.maxDuration > 0 && w.tags.Duration() >= w.maxDuration) {
+		return len(p), errSplit
+	}
+	return len(p), nil
+}
+
+// flvProgressWriter passes the bytes written and the time covered by the tags seen so far to a meter
+type flvProgressWriter struct {
+	tags    *flvTagReader
+	meter   *recorder.ProgressMeter
+	written int64
+}
+
+func (w *flvProgressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	w.meter.Update(w.tags.Duration(), w.written, 0)
+	return len(p), nil
+}
+
+// flvTagReader follows the FLV tag structure of a byte stream to find the last tag timestamp
+type flvTagReader struct {
+	header    []byte
+	skip      int64
+	first     int64
+	last      int64
+	hasFirst  bool
+	headerLen int
+}
+
+const (
+	flvFileHeaderSize = 9 + 4 // File header followed by the first PreviousTagSize
+	flvTagHeaderSize  = 11
+)
+
+func (r *flvTagReader) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if r.headerLen < flvFileHeaderSize {
+			take := min(flvFileHeaderSize-r.headerLen, len(p))
+			r.headerLen += take
+			p = p[take:]
+			continue
+		}
+		if r.skip > 0 {
+			take := min(r.skip, int64(len(p)))
+			r.skip -= take
+			p = p[take:]
+			continue
+		}
+
+		take := min(flvTagHeaderSize-len(r.header), len(p))
+		r.header = append(r.header, p[:take]...)
+		p = p[take:]
+		if len(r.header) < flvTagHeaderSize {
+			continue
+		}
+
+		// Tag header: type (1), data size (3), timestamp (3), timestamp extension (1), stream id (3)
+		dataSize := int64(binary.BigEndian.Uint32(append([]byte{0}, r.header[1:4]...)))
+		timestamp := int64(binary.BigEndian.Uint32(append([]byte{r.header[7]}, r.header[4:7]...)))
+		if !r.hasFirst {
+			r.first = timestamp
+			r.hasFirst = true
+		}
+		r.last = timestamp
+		r.header = r.header[:0]
+		r.skip = dataSize + 4 // Tag data followed by PreviousTagSize
+	}
+	return n, nil
+}
+
+// Duration returns the time between the first and the last tag seen
+func (r *flvTagReader) Duration() time.Duration {
+	return time.Duration(r.last-r.first) * time.Millisecond
+}
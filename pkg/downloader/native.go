@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(BackendNative, NewNative)
+}
+
+// NativeDownloader records HLS streams into MPEG-TS with the pure Go engine, without ffmpeg
+type NativeDownloader struct {
+	remuxMP4      bool
+	splitDuration time.Duration
+	splitSize     int64
+}
+
+func NewNative() recorder.Downloader {
+	return &NativeDownloader{}
+}
+
+func (d *NativeDownloader) Name() string {
+	return BackendNative
+}
+
+func (d *NativeDownloader) Capabilities() recorder.DownloaderCapabilities {
+	container := recorder.FormatTS
+	if d.remuxMP4 {
+		container = recorder.FormatMP4
+	}
+	return recorder.DownloaderCapabilities{
+		Protocols:      []string{recorder.ProtocolHLS},
+		Container:      container,
+		RequiresFFmpeg: d.remuxMP4,
+		CrashSafe:      true,
+	}
+}
+
+// SetFormat only accepts MPEG-TS, the final remux to MP4 needs ffmpeg
+func (d *NativeDownloader) SetFormat(format string, remuxMP4 bool) error {
+	if format != "" && format != recorder.FormatTS {
+		return fmt.Errorf("native downloader only writes %s, got %s", recorder.FormatTS, format)
+	}
+	d.remuxMP4 = remuxMP4
+	return nil
+}
+
+// SetSplit ends a download after the segment reaching the given duration or size
+func (d *NativeDownloader) SetSplit(duration time.Duration, size int64) {
+	d.splitDuration = duration
+	d.splitSize = size
+}
+
+func (d *NativeDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	result := utils.DownloadHLSNativeWithOptions(ctx, url, &outputPath, &utils.DownloadOptions{
+		SplitDuration: d.splitDuration,
+		SplitSize:     d.splitSize,
+	})
+	if d.remuxMP4 && result.OutputPath != "" && result.Size > 0 {
+		// MPEG-TS has no place for most tags, they are written by the remux
+		remuxed, err := utils.RemuxToMP4(context.Background(), result.OutputPath, utils.LiveMetadata(recorder.LiveFromContext(ctx)))
+		if err != nil {
+			logrus.Warnf("Keeping %s as it is: %v", result.OutputPath, err)
+		}
+		result.OutputPath = remuxed
+		if fileInfo, err := os.Stat(remuxed); err == nil {
+			result.Size = fileInfo.Size()
+		}
+	}
+	return result
+}
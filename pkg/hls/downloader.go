@@ -0,0 +1,494 @@
+// Package hls is a pure Go HLS recorder. It follows master and media playlists,
+// polls live playlists, downloads segments concurrently and writes them in order,
+// so a stream can be recorded without an external ffmpeg binary.
+package hls
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Default options
+const (
+	DefaultConcurrency     = 3
+	DefaultSegmentRetries  = 3
+	DefaultPlaylistRetries = 5
+	DefaultStallTimeout    = 60 * time.Second
+)
+
+// recentSegmentsLimit bounds how many segment URIs are remembered for duplicate detection
+const recentSegmentsLimit = 512
+
+// errLimitReached stops the writer once MaxBytes or MaxDuration is reached
+var errLimitReached = errors.New("download limit reached")
+
+// Options configures a download, zero values fall back to the defaults
+type Options struct {
+	HTTPClient *http.Client
+	Header     http.Header
+	// Concurrency is the number of segments downloaded at the same time
+	Concurrency int
+	// SegmentRetries is how many times a failing segment is retried before it is skipped
+	SegmentRetries int
+	// PlaylistRetries is how many consecutive playlist failures end the download
+	PlaylistRetries int
+	// PollInterval overrides the playlist reload interval derived from the target duration
+	PollInterval time.Duration
+	// StallTimeout ends the download when a live playlist has no new segments for this long
+	StallTimeout time.Duration
+	// SelectVariant picks the variant of a master playlist, the highest bandwidth by default
+	SelectVariant func(master *MasterPlaylist) (Variant, bool)
+	// MaxBytes and MaxDuration end the download after the segment that reaches them, zero means no limit
+	MaxBytes    int64
+	MaxDuration time.Duration
+	// Progress is called with the stats after every written segment, from the goroutine writing them
+	Progress func(stats Stats)
+}
+
+// Stats summarizes a download
+type Stats struct {
+	Segments        int
+	Bytes           int64
+	Duration        time.Duration // Sum of the written segment durations
+	Discontinuities int
+	Duplicates      int  // Segments announced again under a new sequence number
+	Skipped         int  // Segments that failed to download
+	Limited         bool // Ended by MaxBytes or MaxDuration
+}
+
+type job struct {
+	segment Segment
+	data    []byte
+	err     error
+	done    chan struct{}
+}
+
+type downloader struct {
+	opts Options
+	w    io.Writer
+
+	mu      sync.Mutex
+	stats   Stats
+	keys    map[string][]byte
+	lastMap string
+}
+
+// DownloadFile records the HLS stream at playlistURL into outputPath as it arrives
+func DownloadFile(ctx context.Context, playlistURL string, outputPath string, opts *Options) (*Stats, error) {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Download(ctx, playlistURL, file, opts)
+}
+
+// Download records the HLS stream at playlistURL into w until the playlist ends,
+// stops updating or ctx is cancelled. Segments are written in playlist order,
+// duplicates are dropped and segments that keep failing are skipped.
+// On cancellation the stats of what was written are returned together with ctx.Err().
+func Download(ctx context.Context, playlistURL string, w io.Writer, opts *Options) (*Stats, error) {
+	d := &downloader{w: w, keys: make(map[string][]byte)}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.HTTPClient == nil {
+		d.opts.HTTPClient = http.DefaultClient
+	}
+	if d.opts.Concurrency < 1 {
+		d.opts.Concurrency = DefaultConcurrency
+	}
+	if d.opts.SegmentRetries < 1 {
+		d.opts.SegmentRetries = DefaultSegmentRetries
+	}
+	if d.opts.PlaylistRetries < 1 {
+		d.opts.PlaylistRetries = DefaultPlaylistRetries
+	}
+	if d.opts.StallTimeout <= 0 {
+		d.opts.StallTimeout = DefaultStallTimeout
+	}
+	if d.opts.SelectVariant == nil {
+		d.opts.SelectVariant = (*MasterPlaylist).BestVariant
+	}
+
+	mediaURL, err := d.resolveMediaPlaylist(ctx, playlistURL)
+	if err != nil {
+		return &Stats{}, err
+	}
+
+	// A write error cancels the polling loop through runCtx
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan *job, d.opts.Concurrency)
+	writerDone := make(chan error, 1)
+	go func() {
+		writerDone <- d.writeLoop(runCtx, queue, cancel)
+	}()
+
+	endErr := d.pollLoop(runCtx, mediaURL, queue)
+	close(queue)
+	writeErr := <-writerDone
+
+	stats := d.snapshot()
+	switch {
+	case writeErr == errLimitReached:
+		return stats, nil
+	case writeErr != nil:
+		return stats, writeErr
+	case ctx.Err() != nil:
+		return stats, ctx.Err()
+	case endErr != nil && stats.Segments == 0:
+		return stats, endErr
+	}
+	return stats, nil
+}
+
+func (d *downloader) snapshot() *Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := d.stats
+	return &stats
+}
+
+// resolveMediaPlaylist follows a master playlist to the selected variant
+func (d *downloader) resolveMediaPlaylist(ctx context.Context, playlistURL string) (string, error) {
+	data, err := d.fetch(ctx, playlistURL)
+	if err != nil {
+		return "", err
+	}
+
+	master, _, err := ParsePlaylist(data, playlistURL)
+	if err != nil {
+		return "", err
+	}
+	if master == nil {
+		return playlistURL, nil
+	}
+
+	variant, ok := d.opts.SelectVariant(master)
+	if !ok {
+		return "", fmt.Errorf("no variant found in master playlist %s", playlistURL)
+	}
+	logrus.Debugf("Selected HLS variant %s (bandwidth %d)", variant.URI, variant.Bandwidth)
+	return variant.URI, nil
+}
+
+// pollLoop reloads the media playlist and queues new segments until the stream ends.
+// It returns the last playlist error when the playlist became unavailable.
+func (d *downloader) pollLoop(ctx context.Context, mediaURL string, queue chan<- *job) error {
+	var (
+		last       *Segment
+		failures   int
+		lastNewAt  = time.Now()
+		recent     = make(map[string]bool)
+		recentList = make([]string, 0, recentSegmentsLimit)
+	)
+
+	for {
+		data, err := d.fetch(ctx, mediaURL)
+		var media *MediaPlaylist
+		if err == nil {
+			_, media, err = ParsePlaylist(data, mediaURL)
+			if err == nil && media == nil {
+				err = fmt.Errorf("expected a media playlist at %s", mediaURL)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			failures++
+			if failures >= d.opts.PlaylistRetries {
+				logrus.Infof("HLS playlist unavailable after %d attempts, stopping: %v", failures, err)
+				return err
+			}
+			logrus.Warnf("Failed to reload HLS playlist (attempt %d): %v", failures, err)
+			if !sleep(ctx, time.Duration(failures)*time.Second) {
+				return nil
+			}
+			continue
+		}
+		failures = 0
+
+		// A live playlist whose sequence numbers start over has restarted, which shows as the newest segment
+		// far behind the last written one, or not ahead of it under a higher discontinuity sequence
+		if last != nil && len(media.Segments) > 0 && isReset(media.Segments[len(media.Segments)-1], *last, len(media.Segments)) {
+			logrus.Warnf("HLS media sequence went back from %d to %d, treating as a discontinuity", last.Sequence, media.Segments[len(media.Segments)-1].Sequence)
+			last = nil
+			media.Segments[0].Discontinuity = true
+		}
+
+		newSegments := 0
+		for i := range media.Segments {
+			segment := media.Segments[i]
+			if last != nil && !isNewer(segment, *last) {
+				continue
+			}
+			last = &segment
+
+			if recent[segment.URI] {
+				d.mu.Lock()
+				d.stats.Duplicates++
+				d.mu.Unlock()
+				continue
+			}
+			recent[segment.URI] = true
+			recentList = append(recentList, segment.URI)
+			if len(recentList) > recentSegmentsLimit {
+				delete(recent, recentList[0])
+				recentList = recentList[1:]
+			}
+
+			j := &job{segment: segment, done: make(chan struct{})}
+			select {
+			case queue <- j:
+			case <-ctx.Done():
+				return nil
+			}
+			go func() {
+				j.data, j.err = d.fetchSegment(ctx, j.segment)
+				close(j.done)
+			}()
+			newSegments++
+		}
+
+		if media.EndList {
+			return nil
+		}
+
+		if newSegments > 0 {
+			lastNewAt = time.Now()
+		} else if time.Since(lastNewAt) > d.opts.StallTimeout {
+			logrus.Infof("HLS playlist has no new segments for %v, stopping", d.opts.StallTimeout)
+			return nil
+		}
+
+		// Reload after a target duration, or half of it when nothing changed (RFC 8216 6.3.4)
+		interval := d.opts.PollInterval
+		if interval <= 0 {
+			interval = media.TargetDuration
+			if newSegments == 0 {
+				interval /= 2
+			}
+			if interval <= 0 {
+				interval = time.Second
+			}
+		}
+		if !sleep(ctx, interval) {
+			return nil
+		}
+	}
+}
+
+// writeLoop writes finished segments in queue order
+func (d *downloader) writeLoop(ctx context.Context, queue <-chan *job, cancel context.CancelFunc) error {
+	var writeErr error
+	for j := range queue {
+		<-j.done
+		if writeErr != nil {
+			continue
+		}
+		if j.err != nil {
+			if ctx.Err() == nil {
+				logrus.Warnf("Skipping HLS segment %d: %v", j.segment.Sequence, j.err)
+				d.mu.Lock()
+				d.stats.Skipped++
+				d.mu.Unlock()
+			}
+			continue
+		}
+
+		if err := d.writeSegment(ctx, j); err != nil {
+			writeErr = err
+			cancel()
+		}
+	}
+	return writeErr
+}
+
+func (d *downloader) writeSegment(ctx context.Context, j *job) error {
+	// fMP4 streams need their initialization section before the first segment and after it changes
+	if j.segment.Map != nil && j.segment.Map.URI != d.lastMap {
+		initData, err := d.fetch(ctx, j.segment.Map.URI)
+		if err != nil {
+			return fmt.Errorf("failed to fetch init section: %w", err)
+		}
+		if _, err := d.w.Write(initData); err != nil {
+			return err
+		}
+		d.lastMap = j.segment.Map.URI
+		d.mu.Lock()
+		d.stats.Bytes += int64(len(initData))
+		d.mu.Unlock()
+	}
+
+	n, err := d.w.Write(j.data)
+	stats, err := d.countSegment(j.segment, n, err)
+	if d.opts.Progress != nil && n > 0 {
+		d.opts.Progress(stats)
+	}
+	return err
+}
+
+// countSegment adds n bytes of segment to the stats and returns them,
+// failing with writeErr or with errLimitReached once a limit is reached
+func (d *downloader) countSegment(segment Segment, n int, writeErr error) (Stats, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Bytes += int64(n)
+	if writeErr != nil {
+		return d.stats, writeErr
+	}
+
+	d.stats.Segments++
+	d.stats.Duration += segment.Duration
+	if segment.Discontinuity && d.stats.Segments > 1 {
+		d.stats.Discontinuities++
+	}
+
+	if (d.opts.MaxBytes > 0 && d.stats.Bytes >= d.opts.MaxBytes) ||
+		(d.opts.MaxDuration > 0 && d.stats.Duration >= d.opts.MaxDuration) {
+		d.stats.Limited = true
+		return d.stats, errLimitReached
+	}
+	return d.stats, nil
+}
+
+// fetchSegment downloads and decrypts a segment, retrying on failure
+func (d *downloader) fetchSegment(ctx context.Context, segment Segment) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt < d.opts.SegmentRetries; attempt++ {
+		if attempt > 0 && !sleep(ctx, time.Duration(attempt)*500*time.Millisecond) {
+			return nil, ctx.Err()
+		}
+
+		var data []byte
+		data, err = d.fetch(ctx, segment.URI)
+		if err != nil {
+			continue
+		}
+		if segment.Key == nil {
+			return data, nil
+		}
+		return d.decrypt(ctx, segment, data)
+	}
+	return nil, err
+}
+
+func (d *downloader) decrypt(ctx context.Context, segment Segment, data []byte) ([]byte, error) {
+	if segment.Key.Method != "AES-128" {
+		return nil, fmt.Errorf("unsupported encryption method %s", segment.Key.Method)
+	}
+
+	d.mu.Lock()
+	key, ok := d.keys[segment.Key.URI]
+	d.mu.Unlock()
+	if !ok {
+		var err error
+		key, err = d.fetch(ctx, segment.Key.URI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch key: %w", err)
+		}
+		d.mu.Lock()
+		d.keys[segment.Key.URI] = key
+		d.mu.Unlock()
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted segment is not a multiple of the block size")
+	}
+
+	// Without an explicit IV the media sequence number is used (RFC 8216 5.2)
+	iv := segment.Key.IV
+	if len(iv) != aes.BlockSize {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(segment.Sequence))
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// Remove PKCS#7 padding
+	if len(plain) > 0 {
+		padding := int(plain[len(plain)-1])
+		if padding > 0 && padding <= aes.BlockSize && padding <= len(plain) &&
+			bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+			plain = plain[:len(plain)-padding]
+		}
+	}
+	return plain, nil
+}
+
+func (d *downloader) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range d.opts.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	resp, err := d.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// isNewer reports whether a comes after b in the stream. The media sequence keeps increasing across
+// discontinuities, while the discontinuity sequence of a playlist without EXT-X-DISCONTINUITY-SEQUENCE
+// goes down once a discontinuity scrolls out of the window.
+func isNewer(a, b Segment) bool {
+	return a.Sequence > b.Sequence
+}
+
+// isReset reports whether newest, the last segment of a playlist of size segments, shows that the playlist
+// started over after last was written
+func isReset(newest, last Segment, size int) bool {
+	if newest.Sequence < last.Sequence-int64(size) {
+		return true
+	}
+	return newest.DiscontinuitySequence > last.DiscontinuitySequence && newest.Sequence <= last.Sequence
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
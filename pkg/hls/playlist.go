@@ -0,0 +1,245 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variant is a stream listed in a master playlist
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Resolution string
+	Codecs     string
+}
+
+// MasterPlaylist lists the variants of a stream
+type MasterPlaylist struct {
+	Variants []Variant
+}
+
+// Key describes how the following segments are encrypted (EXT-X-KEY)
+type Key struct {
+	Method string
+	URI    string
+	IV     []byte
+}
+
+// Map is an initialization section that must precede the following segments (EXT-X-MAP)
+type Map struct {
+	URI string
+}
+
+// Segment is a media segment of a media playlist
+type Segment struct {
+	URI      string
+	Duration time.Duration
+	// Sequence is the media sequence number of the segment
+	Sequence int64
+	// DiscontinuitySequence increases after every EXT-X-DISCONTINUITY
+	DiscontinuitySequence int64
+	Discontinuity         bool
+	Key                   *Key
+	Map                   *Map
+}
+
+// MediaPlaylist lists the segments of a single variant
+type MediaPlaylist struct {
+	TargetDuration        time.Duration
+	MediaSequence         int64
+	DiscontinuitySequence int64
+	Segments              []Segment
+	EndList               bool
+}
+
+// ErrNotPlaylist is returned when the content does not start with #EXTM3U
+var ErrNotPlaylist = errors.New("not an m3u8 playlist")
+
+// ParsePlaylist parses a master or media playlist, exactly one of the results is non-nil.
+// Relative URIs are resolved against baseURL.
+func ParsePlaylist(data []byte, baseURL string) (*MasterPlaylist, *MediaPlaylist, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff")), "#EXTM3U") {
+		return nil, nil, ErrNotPlaylist
+	}
+
+	var (
+		master        *MasterPlaylist
+		media         = &MediaPlaylist{}
+		pendingVar    *Variant
+		duration      time.Duration
+		discontinuity bool
+		discSeq       int64
+		key           *Key
+		segmentMap    *Map
+		lineNumber    = 1
+	)
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch {
+		case tag == "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			pendingVar = &Variant{
+				Bandwidth:  bandwidth,
+				Resolution: attrs["RESOLUTION"],
+				Codecs:     attrs["CODECS"],
+			}
+		case tag == "#EXT-X-TARGETDURATION":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid target duration %q", lineNumber, value)
+			}
+			media.TargetDuration = time.Duration(seconds) * time.Second
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			sequence, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid media sequence %q", lineNumber, value)
+			}
+			media.MediaSequence = sequence
+		case tag == "#EXT-X-DISCONTINUITY-SEQUENCE":
+			sequence, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid discontinuity sequence %q", lineNumber, value)
+			}
+			media.DiscontinuitySequence = sequence
+			discSeq = sequence
+		case tag == "#EXTINF":
+			seconds, _, _ := strings.Cut(value, ",")
+			f, err := strconv.ParseFloat(strings.TrimSpace(seconds), 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid segment duration %q", lineNumber, value)
+			}
+			duration = time.Duration(f * float64(time.Second))
+		case tag == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+			discSeq++
+		case tag == "#EXT-X-KEY":
+			attrs := parseAttributes(value)
+			key = nil
+			if attrs["METHOD"] != "" && attrs["METHOD"] != "NONE" {
+				key = &Key{Method: attrs["METHOD"], URI: resolveURI(base, attrs["URI"])}
+				if iv := attrs["IV"]; iv != "" {
+					key.IV, err = parseHex(iv)
+					if err != nil {
+						return nil, nil, fmt.Errorf("line %d: invalid key IV %q", lineNumber, iv)
+					}
+				}
+			}
+		case tag == "#EXT-X-MAP":
+			segmentMap = &Map{URI: resolveURI(base, parseAttributes(value)["URI"])}
+		case tag == "#EXT-X-ENDLIST":
+			media.EndList = true
+		case strings.HasPrefix(line, "#"):
+			// Comments and unsupported tags
+		default:
+			if pendingVar != nil {
+				pendingVar.URI = resolveURI(base, line)
+				if master == nil {
+					master = &MasterPlaylist{}
+				}
+				master.Variants = append(master.Variants, *pendingVar)
+				pendingVar = nil
+				continue
+			}
+			media.Segments = append(media.Segments, Segment{
+				URI:                   resolveURI(base, line),
+				Duration:              duration,
+				Sequence:              media.MediaSequence + int64(len(media.Segments)),
+				DiscontinuitySequence: discSeq,
+				Discontinuity:         discontinuity,
+				Key:                   key,
+				Map:                   segmentMap,
+			})
+			duration = 0
+			discontinuity = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if master != nil {
+		return master, nil, nil
+	}
+	return nil, media, nil
+}
+
+// BestVariant returns the variant with the highest bandwidth
+func (m *MasterPlaylist) BestVariant() (Variant, bool) {
+	if len(m.Variants) == 0 {
+		return Variant{}, false
+	}
+	best := m.Variants[0]
+	for _, variant := range m.Variants[1:] {
+		if variant.Bandwidth > best.Bandwidth {
+			best = variant
+		}
+	}
+	return best, true
+}
+
+// parseAttributes parses an attribute list like BANDWIDTH=1280000,CODECS="avc1,mp4a"
+func parseAttributes(value string) map[string]string {
+	attrs := make(map[string]string)
+	for len(value) > 0 {
+		name, rest, found := strings.Cut(value, "=")
+		if !found {
+			break
+		}
+		name = strings.TrimSpace(name)
+
+		var attrValue string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				attrValue, rest = rest[1:], ""
+			} else {
+				attrValue, rest = rest[1:end+1], rest[end+2:]
+			}
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			attrValue, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[name] = strings.TrimSpace(attrValue)
+		value = rest
+	}
+	return attrs
+}
+
+func resolveURI(base *url.URL, uri string) string {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return base.ResolveReference(ref).String()
+}
+
+func parseHex(value string) ([]byte, error) {
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	if len(value)%2 != 0 {
+		value = "0" + value
+	}
+	return hex.DecodeString(value)
+}
@@ -0,0 +1,87 @@
+package naming
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+)
+
+// Config picks the template of a live. The most specific rule wins:
+// a rule for "platform/username", then a rule for "platform", then the default template.
+type Config struct {
+	Default *Template
+	Rules   map[string]*Template
+}
+
+// NewConfig returns a config using DefaultTemplate for every live
+func NewConfig() *Config {
+	return &Config{
+		Default: MustParse(DefaultTemplate),
+		Rules:   make(map[string]*Template),
+	}
+}
+
+// SetDefault replaces the default template
+func (c *Config) SetDefault(template string) error {
+	t, err := Parse(template)
+	if err != nil {
+		return err
+	}
+	c.Default = t
+	return nil
+}
+
+// SetRule sets the template for a platform ("idn") or a streamer on a platform ("idn/username")
+func (c *Config) SetRule(key string, template string) error {
+	if key == "" || strings.Count(key, "/") > 1 {
+		return fmt.Errorf("invalid template rule key %q, expected platform or platform/username", key)
+	}
+	t, err := Parse(template)
+	if err != nil {
+		return err
+	}
+	if c.Rules == nil {
+		c.Rules = make(map[string]*Template)
+	}
+	c.Rules[strings.ToLower(key)] = t
+	return nil
+}
+
+// ParseRule sets a rule written as key=template
+func (c *Config) ParseRule(rule string) error {
+	key, template, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("invalid template rule %q, expected key=template", rule)
+	}
+	return c.SetRule(strings.TrimSpace(key), strings.TrimSpace(template))
+}
+
+// Template returns the template to use for live
+func (c *Config) Template(live *recorder.Live) *Template {
+	platform := strings.ToLower(live.Platform)
+	if live.Streamer != nil {
+		if t, ok := c.Rules[platform+"/"+strings.ToLower(live.Streamer.Username)]; ok {
+			return t
+		}
+	}
+	if t, ok := c.Rules[platform]; ok {
+		return t
+	}
+	if c.Default == nil {
+		return MustParse(DefaultTemplate)
+	}
+	return c.Default
+}
+
+// OutputPath returns the template of live joined to dir, in the form expected by session.Record,
+// which renders it again for every output file
+func (c *Config) OutputPath(dir string, live *recorder.Live) string {
+	return filepath.Join(Escape(dir), c.Template(live).String())
+}
+
+// Escape doubles the braces of s so it renders as literal text
+func Escape(s string) string {
+	return strings.NewReplacer("{", "{{", "}", "}}").Replace(s)
+}
@@ -0,0 +1,174 @@
+// Package naming renders output paths of recordings from templates such as
+//
+//	{platform}/{username}/{date}_{title}_{part}.mp4
+//
+// Fields are written in braces, a literal brace is written twice ({{ or }}).
+// Text fields are sanitized so they never create directories or invalid file names.
+package naming
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+)
+
+// DefaultTemplate keeps the historical layout: <platform>/<username>_<unix timestamp>.mp4
+const DefaultTemplate = "{platform}/{username}_{unix}.mp4"
+
+// maxFieldLength bounds the length of a single text field, long titles are cut
+const maxFieldLength = 80
+
+// Fields holds the values a template is rendered with
+type Fields struct {
+	Live *recorder.Live
+	Part int       // Number of the output file within the session, starting at 1
+	Time time.Time // Start of the output file, already in the wanted timezone
+}
+
+var fieldFuncs = map[string]func(f *Fields) string{
+	"platform": func(f *Fields) string { return Sanitize(f.Live.Platform) },
+	"id":       func(f *Fields) string { return Sanitize(f.Live.ID) },
+	"title":    func(f *Fields) string { return Sanitize(f.Live.Title) },
+	"username": username,
+	"name": func(f *Fields) string {
+		if f.Live.Streamer == nil || f.Live.Streamer.Name == "" {
+			return username(f)
+		}
+		return Sanitize(f.Live.Streamer.Name)
+	},
+	"part":     func(f *Fields) string { return fmt.Sprintf("%03d", max(f.Part, 1)) },
+	"unix":     func(f *Fields) string { return strconv.FormatInt(f.Time.Unix(), 10) },
+	"date":     func(f *Fields) string { return f.Time.Format("2006-01-02") },
+	"time":     func(f *Fields) string { return f.Time.Format("15-04-05") },
+	"datetime": func(f *Fields) string { return f.Time.Format("2006-01-02_15-04-05") },
+	"year":     func(f *Fields) string { return f.Time.Format("2006") },
+	"month":    func(f *Fields) string { return f.Time.Format("01") },
+	"day":      func(f *Fields) string { return f.Time.Format("02") },
+	"hour":     func(f *Fields) string { return f.Time.Format("15") },
+	"minute":   func(f *Fields) string { return f.Time.Format("04") },
+	"second":   func(f *Fields) string { return f.Time.Format("05") },
+}
+
+func username(f *Fields) string {
+	if f.Live.Streamer == nil {
+		return "unknown"
+	}
+	return Sanitize(f.Live.Streamer.Username)
+}
+
+// FieldNames returns the sorted names of all template fields
+func FieldNames() []string {
+	names := make([]string, 0, len(fieldFuncs))
+	for name := range fieldFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Template is a parsed output path template
+type Template struct {
+	raw   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	text  string
+	field string
+}
+
+// Parse parses a template, failing on unknown fields and unbalanced braces
+func Parse(s string) (*Template, error) {
+	t := &Template{raw: s}
+	var text strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			text.WriteByte(s[i])
+			i++
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed field at position %d in template %q", i+1, s)
+			}
+			field := s[i+1 : i+end]
+			if _, ok := fieldFuncs[field]; !ok {
+				return nil, fmt.Errorf("unknown field {%s} in template %q, expected one of %s", field, s, strings.Join(FieldNames(), ", "))
+			}
+			if text.Len() > 0 {
+				t.parts = append(t.parts, templatePart{text: text.String()})
+				text.Reset()
+			}
+			t.parts = append(t.parts, templatePart{field: field})
+			i += end
+		case s[i] == '}':
+			return nil, fmt.Errorf("unexpected } at position %d in template %q", i+1, s)
+		default:
+			text.WriteByte(s[i])
+		}
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, templatePart{text: text.String()})
+	}
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("empty template")
+	}
+	return t, nil
+}
+
+// MustParse is like Parse but panics on an invalid template
+func MustParse(s string) *Template {
+	t, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the template as it was parsed
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Render returns the path for the given fields
+func (t *Template) Render(fields Fields) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.text)
+			continue
+		}
+		b.WriteString(fieldFuncs[part.field](&fields))
+	}
+	return filepath.Clean(b.String())
+}
+
+// Sanitize makes s safe to use as a single path element
+func Sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+
+	if utf8.RuneCountInString(s) > maxFieldLength {
+		s = string([]rune(s)[:maxFieldLength])
+	}
+	s = strings.Trim(s, " .")
+	if s == "" {
+		return "_"
+	}
+	return s
+}
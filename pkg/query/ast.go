@@ -0,0 +1,212 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+)
+
+// Expr is a node of a parsed filter expression.
+// Every Expr satisfies recorder.LiveFilter.
+type Expr interface {
+	Match(live *recorder.Live) bool
+	String() string
+}
+
+// AndExpr matches when both sides match
+type AndExpr struct {
+	Left  Expr
+	Right Expr
+}
+
+func (e *AndExpr) Match(live *recorder.Live) bool {
+	return e.Left.Match(live) && e.Right.Match(live)
+}
+
+func (e *AndExpr) String() string {
+	return fmt.Sprintf("(%s AND %s)", e.Left, e.Right)
+}
+
+// OrExpr matches when either side matches
+type OrExpr struct {
+	Left  Expr
+	Right Expr
+}
+
+func (e *OrExpr) Match(live *recorder.Live) bool {
+	return e.Left.Match(live) || e.Right.Match(live)
+}
+
+func (e *OrExpr) String() string {
+	return fmt.Sprintf("(%s OR %s)", e.Left, e.Right)
+}
+
+// NotExpr inverts its operand
+type NotExpr struct {
+	Expr Expr
+}
+
+func (e *NotExpr) Match(live *recorder.Live) bool {
+	return !e.Expr.Match(live)
+}
+
+func (e *NotExpr) String() string {
+	return fmt.Sprintf("NOT %s", e.Expr)
+}
+
+// StringComparison compares a text field.
+// "=" and "!=" are case-insensitive and accept * wildcards, "~" and "!~" match a case-insensitive regex.
+type StringComparison struct {
+	Field   string
+	Op      string
+	Value   string
+	pattern *regexp.Regexp
+}
+
+func (e *StringComparison) Match(live *recorder.Live) bool {
+	matched := e.pattern.MatchString(stringFields[e.Field](live))
+	if e.Op == "!=" || e.Op == "!~" {
+		return !matched
+	}
+	return matched
+}
+
+func (e *StringComparison) String() string {
+	return fmt.Sprintf("%s %s %q", e.Field, e.Op, e.Value)
+}
+
+// NumberComparison compares a numeric field
+type NumberComparison struct {
+	Field string
+	Op    string
+	Value int64
+}
+
+func (e *NumberComparison) Match(live *recorder.Live) bool {
+	return compare(numberFields[e.Field](live), e.Op, e.Value)
+}
+
+func (e *NumberComparison) String() string {
+	return fmt.Sprintf("%s %s %d", e.Field, e.Op, e.Value)
+}
+
+// DurationComparison compares the time elapsed since the live started.
+// It never matches a live without a start time.
+type DurationComparison struct {
+	Field string
+	Op    string
+	Value time.Duration
+}
+
+func (e *DurationComparison) Match(live *recorder.Live) bool {
+	if live.StartedAt == nil {
+		return false
+	}
+	return compare(int64(time.Since(*live.StartedAt)), e.Op, int64(e.Value))
+}
+
+func (e *DurationComparison) String() string {
+	return fmt.Sprintf("%s %s %s", e.Field, e.Op, e.Value)
+}
+
+func compare(left int64, op string, right int64) bool {
+	switch op {
+	case "=", "==":
+		return left == right
+	case "!=":
+		return left != right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	}
+	return false
+}
+
+var stringFields = map[string]func(live *recorder.Live) string{
+	"id":       func(live *recorder.Live) string { return live.ID },
+	"title":    func(live *recorder.Live) string { return live.Title },
+	"platform": func(live *recorder.Live) string { return live.Platform },
+	"streamer_username": func(live *recorder.Live) string {
+		if live.Streamer == nil {
+			return ""
+		}
+		return live.Streamer.Username
+	},
+	"streamer_name": func(live *recorder.Live) string {
+		if live.Streamer == nil {
+			return ""
+		}
+		return live.Streamer.Name
+	},
+}
+
+var numberFields = map[string]func(live *recorder.Live) int64{
+	"view_count": func(live *recorder.Live) int64 { return int64(live.ViewCount) },
+	"follower_count": func(live *recorder.Live) int64 {
+		if live.Streamer == nil {
+			return 0
+		}
+		return int64(live.Streamer.FollowerCount)
+	},
+}
+
+// durationFields holds fields measured as time elapsed since started_at
+var durationFields = map[string]bool{
+	"uptime": true,
+}
+
+var fieldAliases = map[string]string{
+	"username":  "streamer_username",
+	"streamer":  "streamer_username",
+	"name":      "streamer_name",
+	"views":     "view_count",
+	"followers": "follower_count",
+	"live_for":  "uptime",
+}
+
+// Fields returns the names of all fields usable in an expression
+func Fields() []string {
+	names := make([]string, 0, len(stringFields)+len(numberFields)+len(durationFields))
+	for name := range stringFields {
+		names = append(names, name)
+	}
+	for name := range numberFields {
+		names = append(names, name)
+	}
+	for name := range durationFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// wildcardPattern compiles a case-insensitive exact match where * matches any text
+func wildcardPattern(value string) *regexp.Regexp {
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+}
+
+// parseDuration accepts Go durations (90s, 1h30m) and whole days (2d)
+func parseDuration(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
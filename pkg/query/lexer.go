@@ -0,0 +1,143 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+// token is a lexical token with its 1-based position in the query
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.value)
+	case tokenOperator:
+		return fmt.Sprintf("operator %q", t.value)
+	case tokenLParen, tokenRParen:
+		return fmt.Sprintf("%q", t.value)
+	case tokenAnd, tokenOr, tokenNot:
+		return strings.ToUpper(t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// operators sorted so that two-character operators are tried first
+var operators = []string{"==", "!=", "!~", "<=", ">=", "&&", "||", "=", "~", "<", ">", "!"}
+
+func isWordBreak(c byte) bool {
+	return strings.IndexByte(" \t\r\n()=!<>~\"'&|", c) >= 0
+}
+
+// tokenize splits a query into tokens
+func tokenize(query string) ([]token, error) {
+	tokens := make([]token, 0)
+	i := 0
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, value: "(", pos: i + 1})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, value: ")", pos: i + 1})
+			i++
+		case c == '"' || c == '\'':
+			value, next, err := readString(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value, pos: i + 1})
+			i = next
+		case isWordBreak(c):
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(query[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &SyntaxError{Query: query, Pos: i + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, operatorToken(op, i+1))
+			i += len(op)
+		default:
+			start := i
+			for i < len(query) && !isWordBreak(query[i]) {
+				i++
+			}
+			tokens = append(tokens, wordToken(query[start:i], start+1))
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(query) + 1})
+	return tokens, nil
+}
+
+func operatorToken(op string, pos int) token {
+	switch op {
+	case "&&":
+		return token{kind: tokenAnd, value: op, pos: pos}
+	case "||":
+		return token{kind: tokenOr, value: op, pos: pos}
+	case "!":
+		return token{kind: tokenNot, value: op, pos: pos}
+	default:
+		return token{kind: tokenOperator, value: op, pos: pos}
+	}
+}
+
+func wordToken(word string, pos int) token {
+	switch strings.ToUpper(word) {
+	case "AND":
+		return token{kind: tokenAnd, value: word, pos: pos}
+	case "OR":
+		return token{kind: tokenOr, value: word, pos: pos}
+	case "NOT":
+		return token{kind: tokenNot, value: word, pos: pos}
+	default:
+		return token{kind: tokenWord, value: word, pos: pos}
+	}
+}
+
+// readString reads a quoted string starting at query[start], supporting backslash escapes
+func readString(query string, start int) (string, int, error) {
+	quote := query[start]
+	var sb strings.Builder
+	for i := start + 1; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\\' && i+1 < len(query):
+			i++
+			sb.WriteByte(query[i])
+		case c == quote:
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, &SyntaxError{Query: query, Pos: start + 1, Msg: "unterminated string"}
+}
@@ -0,0 +1,209 @@
+// Package query implements a filter expression language over recorder.Live fields.
+//
+// Example:
+//
+//	platform = showroom AND (streamer_username = *_JKT48 OR title ~ "jkt48|48") AND NOT view_count < 100 AND uptime < 30m
+//
+// Supported operators are AND (&&), OR (||), NOT (!) and parentheses. Text fields
+// (id, title, platform, streamer_username, streamer_name) accept = and != with * wildcards,
+// and ~ and !~ with a regular expression, both case-insensitive. Numeric fields
+// (view_count, follower_count) and uptime, the time since started_at, accept = != < <= > >=.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError describes an invalid expression and where the problem is
+type SyntaxError struct {
+	Query string
+	Pos   int
+	Msg   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Pos, e.Msg)
+}
+
+// Parse parses a filter expression into an AST
+func Parse(query string) (Expr, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{query: query, tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, p.errorf(p.peek(), "empty query")
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %s, expected AND, OR or end of query", tok)
+	}
+	return expr, nil
+}
+
+// MustParse is like Parse but panics on an invalid expression
+func MustParse(query string) Expr {
+	expr, err := Parse(query)
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
+
+type parser struct {
+	query  string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return &SyntaxError{Query: p.query, Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseOr parses: and { OR and }
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &OrExpr{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary { AND unary }
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &AndExpr{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: NOT unary | "(" or ")" | comparison
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokenNot:
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: expr}, nil
+	case tokenLParen:
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorf(closing, "expected \")\" to close \"(\" at position %d, found %s", tok.pos, closing)
+		}
+		return expr, nil
+	case tokenWord:
+		return p.parseComparison()
+	default:
+		return nil, p.errorf(tok, "unexpected %s, expected a field name, NOT or \"(\"", tok)
+	}
+}
+
+// parseComparison parses: field operator value
+func (p *parser) parseComparison() (Expr, error) {
+	fieldTok := p.next()
+	field := strings.ToLower(fieldTok.value)
+	if alias, ok := fieldAliases[field]; ok {
+		field = alias
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokenOperator {
+		return nil, p.errorf(opTok, "expected an operator after %q, found %s", fieldTok.value, opTok)
+	}
+	op := opTok.value
+
+	valueTok := p.next()
+	if valueTok.kind != tokenWord && valueTok.kind != tokenString {
+		return nil, p.errorf(valueTok, "expected a value after %q, found %s", op, valueTok)
+	}
+	value := valueTok.value
+
+	switch {
+	case stringFields[field] != nil:
+		var pattern *regexp.Regexp
+		switch op {
+		case "=", "==", "!=":
+			pattern = wildcardPattern(value)
+			if op == "==" {
+				op = "="
+			}
+		case "~", "!~":
+			var err error
+			pattern, err = regexp.Compile("(?i)" + value)
+			if err != nil {
+				return nil, p.errorf(valueTok, "invalid regular expression %q: %v", value, err)
+			}
+		default:
+			return nil, p.errorf(opTok, "operator %q is not supported for text field %s, use =, !=, ~ or !~", op, field)
+		}
+		return &StringComparison{Field: field, Op: op, Value: value, pattern: pattern}, nil
+
+	case numberFields[field] != nil:
+		if op == "~" || op == "!~" {
+			return nil, p.errorf(opTok, "operator %q is not supported for numeric field %s", op, field)
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, p.errorf(valueTok, "invalid number %q for field %s", value, field)
+		}
+		return &NumberComparison{Field: field, Op: op, Value: n}, nil
+
+	case durationFields[field]:
+		if op == "~" || op == "!~" {
+			return nil, p.errorf(opTok, "operator %q is not supported for duration field %s", op, field)
+		}
+		d, err := parseDuration(value)
+		if err != nil {
+			return nil, p.errorf(valueTok, "invalid duration %q for field %s, use a value like 90s, 30m, 2h or 1d", value, field)
+		}
+		return &DurationComparison{Field: field, Op: op, Value: d}, nil
+
+	default:
+		return nil, p.errorf(fieldTok, "unknown field %q, expected one of %s", fieldTok.value, strings.Join(Fields(), ", "))
+	}
+}
@@ -0,0 +1,135 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DownloadResult describes the outcome of downloading a stream to a file
+type DownloadResult struct {
+	URL         string        `json:"url"`
+	OutputPath  string        `json:"output_path"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at"`
+	Duration    time.Duration `json:"duration"` // Media duration read from the output file
+	Size        int64         `json:"size"`     // Output size in bytes
+	Segments    int           `json:"segments"` // Number of stream segments fetched
+	ExitCode    int           `json:"exit_code"`
+	Split       bool          `json:"split"` // Stopped at the split limit while the stream goes on
+	Err         error         `json:"-"`
+}
+
+// Elapsed returns the wall-clock time spent downloading
+func (r *DownloadResult) Elapsed() time.Duration {
+	if r.CompletedAt.IsZero() {
+		return time.Since(r.StartedAt)
+	}
+	return r.CompletedAt.Sub(r.StartedAt)
+}
+
+// Stream protocols a downloader can read
+const (
+	ProtocolHLS = "hls"
+	ProtocolFLV = "flv"
+)
+
+// Output formats a downloader can write
+const (
+	FormatMP4  = "mp4"  // Regular MP4, written as fragmented MP4 while recording and finalized at the end
+	FormatFMP4 = "fmp4" // Fragmented MP4, kept fragmented so it stays playable at any point
+	FormatTS   = "ts"   // MPEG-TS
+	FormatMKV  = "mkv"  // Matroska
+)
+
+// DownloaderCapabilities describes what a download backend supports
+type DownloaderCapabilities struct {
+	Protocols      []string `json:"protocols"`       // Stream protocols it can read
+	Container      string   `json:"container"`       // Extension of the files it writes
+	RequiresFFmpeg bool     `json:"requires_ffmpeg"` // Whether an ffmpeg binary must be installed
+	CrashSafe      bool     `json:"crash_safe"`      // Whether a partial output stays playable if the process dies
+}
+
+// Supports reports whether the backend can read the given protocol
+func (c DownloaderCapabilities) Supports(protocol string) bool {
+	return slices.Contains(c.Protocols, protocol)
+}
+
+// StreamProtocol returns the protocol of a streaming url from its path, HLS when it cannot tell
+func StreamProtocol(streamingUrl string) string {
+	path := streamingUrl
+	if u, err := url.Parse(streamingUrl); err == nil {
+		path = u.Path
+	}
+	if strings.HasSuffix(strings.ToLower(path), ".flv") {
+		return ProtocolFLV
+	}
+	return ProtocolHLS
+}
+
+// CheckProtocol returns an error matching ErrUnsupportedProtocol when d cannot read the stream at streamingUrl
+func CheckProtocol(d Downloader, streamingUrl string) error {
+	protocol := StreamProtocol(streamingUrl)
+	if !d.Capabilities().Supports(protocol) {
+		return fmt.Errorf("%w: the %s downloader cannot read %s streams, choose one that can", ErrUnsupportedProtocol, d.Name(), strings.ToUpper(protocol))
+	}
+	return nil
+}
+
+// Downloader saves a stream to a file.
+// Download blocks until the stream ends or ctx is cancelled and never returns nil,
+// a failed download has its Err set. The written file is reported in OutputPath.
+type Downloader interface {
+	Name() string
+	Capabilities() DownloaderCapabilities
+	Download(ctx context.Context, url string, outputPath string) *DownloadResult
+}
+
+type liveContextKey struct{}
+
+// WithLive returns a copy of ctx carrying the live being downloaded,
+// so a Downloader can describe it in the output file
+func WithLive(ctx context.Context, live *Live) context.Context {
+	return context.WithValue(ctx, liveContextKey{}, live)
+}
+
+// LiveFromContext returns the live carried by ctx, or nil when there is none
+func LiveFromContext(ctx context.Context) *Live {
+	live, _ := ctx.Value(liveContextKey{}).(*Live)
+	return live
+}
+
+type outputPathContextKey struct{}
+
+// WithOutputPath returns a copy of ctx asking the downloads run with it to pass fn the path
+// their output ends up at, once they picked it and before they write to it
+func WithOutputPath(ctx context.Context, fn func(outputPath string)) context.Context {
+	return context.WithValue(ctx, outputPathContextKey{}, fn)
+}
+
+// ReportOutputPath passes outputPath to the function of ctx, if there is one
+func ReportOutputPath(ctx context.Context, outputPath string) {
+	if fn, _ := ctx.Value(outputPathContextKey{}).(func(string)); fn != nil {
+		fn(outputPath)
+	}
+}
+
+// FormatSetter is implemented by downloaders that can write more than one output format.
+// With remuxMP4 the output is remuxed to a regular MP4 once the stream ends.
+type FormatSetter interface {
+	SetFormat(format string, remuxMP4 bool) error
+}
+
+// Splitter is implemented by downloaders that can stop at a duration or size limit,
+// so a long stream is saved as several files. Zero disables a limit.
+type Splitter interface {
+	SetSplit(duration time.Duration, size int64)
+}
+
+// DownloaderSetter is implemented by recorders whose Record method uses a replaceable Downloader
+type DownloaderSetter interface {
+	SetDownloader(downloader Downloader)
+}
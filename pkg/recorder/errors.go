@@ -0,0 +1,26 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedProtocol is returned when a downloader cannot read the protocol of a stream
+var ErrUnsupportedProtocol = errors.New("unsupported stream protocol")
+
+// ErrNotLive matches any NotLiveError when used with errors.Is
+var ErrNotLive = errors.New("streamer is not live")
+
+// NotLiveError is returned when a streamer exists but is not live right now
+type NotLiveError struct {
+	Platform string
+	Streamer string
+}
+
+func (e *NotLiveError) Error() string {
+	return fmt.Sprintf("%s streamer %s is not live", e.Platform, e.Streamer)
+}
+
+func (e *NotLiveError) Is(target error) bool {
+	return target == ErrNotLive
+}
@@ -2,19 +2,31 @@ package idn
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strings"
 
+	"github.com/agilistikmal/live-recorder/pkg/downloader"
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
-	"github.com/agilistikmal/live-recorder/utils"
 )
 
+// LiveURLPattern matches an IDN live URL and captures the creator username and the optional livestream slug
+var LiveURLPattern = regexp.MustCompile(`^https?://(?:www\.)?idn\.app/([\w.-]+)/live(?:/([\w-]+))?/?(?:[?#].*)?$`)
+
+func init() {
+	recorder.Register(recorder.PlatformIDN, NewRecorder)
+	recorder.RegisterURLPattern(recorder.PlatformIDN, LiveURLPattern)
+}
+
 type IDNRecorder struct {
 	recorderConfig recorder.RecorderConfig
 	httpClient     *http.Client
+	downloader     recorder.Downloader
 }
 
 func NewRecorder() recorder.Recorder {
@@ -27,95 +39,167 @@ func NewRecorder() recorder.Recorder {
 	return &IDNRecorder{
 		recorderConfig: recorderConfig,
 		httpClient:     httpClient,
+		downloader:     downloader.Default(),
 	}
 }
 
 func (s *IDNRecorder) GetLives() ([]*recorder.Live, error) {
+	return s.GetLivesContext(context.Background())
+}
+
+func (s *IDNRecorder) GetLivesContext(ctx context.Context) ([]*recorder.Live, error) {
 	lives := make([]*recorder.Live, 0)
 
 	page := 1
 	for {
-		query, err := json.Marshal(map[string]any{
-			"query": fmt.Sprintf(`
-				query GetLivestreams {
-					getLivestreams(page: %v) {
-						slug
-						title
-						image_url
-						view_count
-						playback_url
-						status
-						live_at
-						gift_icon_url
-						creator {
-								username
-								name
-								follower_count
-						}
-					}
-				}
-				`, page),
-		})
+		livestreams, err := s.getLivestreams(ctx, page)
 		if err != nil {
 			return nil, err
 		}
 
-		gReq, err := http.NewRequest("POST", "https://api.idn.app/graphql", bytes.NewBuffer(query))
-		if err != nil {
-			return nil, err
-		}
-		gReq.Header.Set("Content-Type", "application/json")
-		gReq.Header.Set("User-Agent", s.recorderConfig.UserAgent)
-		gReq.Header.Set("Referer", s.recorderConfig.Referer)
-		gReq.Header.Set("Cookie", s.recorderConfig.Cookie)
-		resp, err := s.httpClient.Do(gReq)
-		if err != nil {
-			return nil, err
+		if len(livestreams) == 0 {
+			break
 		}
-		defer resp.Body.Close()
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
+		for _, l := range livestreams {
+			if l.Status != "live" {
+				continue
+			}
+			lives = append(lives, l.ToLive())
 		}
+		page++
+	}
+	return lives, nil
+}
 
-		var idnResponses IDNResponses
-		err = json.Unmarshal(body, &idnResponses)
+func (s *IDNRecorder) GetLive(url string) (*recorder.Live, error) {
+	return s.GetLiveContext(context.Background(), url)
+}
+
+// GetLiveContext finds the current livestream for an IDN live URL
+// (https://www.idn.app/<username>/live/<slug>) or a plain creator username.
+// Returns a recorder.NotLiveError when the creator has no running livestream.
+func (s *IDNRecorder) GetLiveContext(ctx context.Context, url string) (*recorder.Live, error) {
+	username := strings.TrimPrefix(strings.TrimSpace(url), "@")
+	slug := ""
+	if matches := LiveURLPattern.FindStringSubmatch(url); len(matches) == 3 {
+		username = matches[1]
+		slug = matches[2]
+	} else if strings.Contains(username, "/") {
+		return nil, fmt.Errorf("invalid idn live url: %s", url)
+	}
+
+	page := 1
+	for {
+		livestreams, err := s.getLivestreams(ctx, page)
 		if err != nil {
 			return nil, err
 		}
 
-		if idnResponses.Data.GetLivestreams == nil {
-			return nil, errors.New("idn response is nil")
-		}
-
-		if len(idnResponses.Data.GetLivestreams) == 0 {
+		if len(livestreams) == 0 {
 			break
 		}
 
-		for _, l := range idnResponses.Data.GetLivestreams {
-			if l.Status != "live" {
+		for _, l := range livestreams {
+			if l.Status != "live" || l.Creator == nil {
 				continue
 			}
-			lives = append(lives, l.ToLive())
+			if slug != "" && l.Slug == slug {
+				return l.ToLive(), nil
+			}
+			if slug == "" && strings.EqualFold(l.Creator.Username, username) {
+				return l.ToLive(), nil
+			}
 		}
 		page++
 	}
-	return lives, nil
-}
 
-func (s *IDNRecorder) GetLive(url string) (*recorder.Live, error) {
-	return nil, nil
+	return nil, &recorder.NotLiveError{Platform: recorder.PlatformIDN, Streamer: username}
 }
 
 func (s *IDNRecorder) GetStreamingUrl(live *recorder.Live) (string, error) {
+	return s.GetStreamingUrlContext(context.Background(), live)
+}
+
+func (s *IDNRecorder) GetStreamingUrlContext(ctx context.Context, live *recorder.Live) (string, error) {
 	return live.StreamingUrl, nil
 }
 
 func (s *IDNRecorder) Record(live *recorder.Live, outputPath string) error {
-	downloadInfo := utils.DownloadHLS(live.StreamingUrl, &outputPath)
-	if downloadInfo == nil {
-		return fmt.Errorf("failed to download hls: %v", live.StreamingUrl)
+	return s.RecordContext(context.Background(), live, outputPath)
+}
+
+func (s *IDNRecorder) RecordContext(ctx context.Context, live *recorder.Live, outputPath string) error {
+	if err := recorder.CheckProtocol(s.downloader, live.StreamingUrl); err != nil {
+		return err
+	}
+	downloadResult := s.downloader.Download(recorder.WithLive(ctx, live), live.StreamingUrl, outputPath)
+	if downloadResult.Err != nil {
+		return fmt.Errorf("failed to download stream: %v: %w", live.StreamingUrl, downloadResult.Err)
 	}
 	return nil
 }
+
+// SetDownloader sets the backend used by Record
+func (s *IDNRecorder) SetDownloader(downloader recorder.Downloader) {
+	s.downloader = downloader
+}
+
+// getLivestreams fetches one page of livestreams from the IDN GraphQL API
+func (s *IDNRecorder) getLivestreams(ctx context.Context, page int) ([]IDNLive, error) {
+	query, err := json.Marshal(map[string]any{
+		"query": fmt.Sprintf(`
+			query GetLivestreams {
+				getLivestreams(page: %v) {
+					slug
+					title
+					image_url
+					view_count
+					playback_url
+					status
+					live_at
+					gift_icon_url
+					creator {
+							username
+							name
+							follower_count
+					}
+				}
+			}
+			`, page),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.idn.app/graphql", bytes.NewBuffer(query))
+	if err != nil {
+		return nil, err
+	}
+	gReq.Header.Set("Content-Type", "application/json")
+	gReq.Header.Set("User-Agent", s.recorderConfig.UserAgent)
+	gReq.Header.Set("Referer", s.recorderConfig.Referer)
+	gReq.Header.Set("Cookie", s.recorderConfig.Cookie)
+	resp, err := s.httpClient.Do(gReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var idnResponses IDNResponses
+	err = json.Unmarshal(body, &idnResponses)
+	if err != nil {
+		return nil, err
+	}
+
+	if idnResponses.Data.GetLivestreams == nil {
+		return nil, errors.New("idn response is nil")
+	}
+
+	return idnResponses.Data.GetLivestreams, nil
+}
@@ -1,6 +1,7 @@
 package idn
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
@@ -43,7 +44,7 @@ func (i *IDNLive) ToLive() *recorder.Live {
 		},
 		Title:        i.Title,
 		Platform:     recorder.PlatformIDN,
-		PlatformUrl:  i.PlaybackUrl,
+		PlatformUrl:  fmt.Sprintf("https://www.idn.app/%s/live/%s", i.Creator.Username, i.Slug),
 		StreamingUrl: i.PlaybackUrl,
 		ImageUrl:     i.ImageUrl,
 		ViewCount:    i.ViewCount,
@@ -1,6 +1,9 @@
 package recorder
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Platform constants
 const (
@@ -24,6 +27,7 @@ type Live struct {
 	Platform     string        `json:"platform"`
 	PlatformUrl  string        `json:"platform_url"`
 	StreamingUrl string        `json:"streaming_url"`
+	Quality      string        `json:"quality,omitempty"` // Quality of StreamingUrl as reported by the platform
 	ImageUrl     string        `json:"image_url"`
 	ViewCount    int           `json:"view_count"`
 	StartedAt    *time.Time    `json:"started_at"`
@@ -37,17 +41,84 @@ type LiveStreamer struct {
 	ImageUrl      string `json:"image_url"`
 }
 
-// LiveQuery represents query parameters for filtering live streams
+// LiveFilter decides whether a live stream matches a query
+type LiveFilter interface {
+	Match(live *Live) bool
+}
+
+// LiveQuery represents query parameters for filtering live streams.
+// Filter, when set, must also match on top of the LIKE filters.
 type LiveQuery struct {
-	Platforms            []string `json:"platforms"`
-	StreamerUsernameLike string   `json:"streamer_username_like"`
-	TitleLike            string   `json:"title_like"`
+	Platforms            []string   `json:"platforms"`
+	StreamerUsernameLike string     `json:"streamer_username_like"`
+	TitleLike            string     `json:"title_like"`
+	Filter               LiveFilter `json:"-"`
+}
+
+// PlatformResult is the outcome of listing lives on a single platform
+type PlatformResult struct {
+	Platform string        `json:"platform"`
+	Lives    []*Live       `json:"lives"`
+	Err      error         `json:"-"`
+	Latency  time.Duration `json:"latency"`
 }
 
-// Recorder is the interface for recording live streams
+// ContextRecorder is the context-aware interface for recording live streams.
+// Cancelling the context aborts in-flight requests and stops a running recording,
+// leaving whatever was captured so far as a playable file.
+type ContextRecorder interface {
+	GetLivesContext(ctx context.Context) ([]*Live, error)
+	GetLiveContext(ctx context.Context, url string) (*Live, error)
+	GetStreamingUrlContext(ctx context.Context, live *Live) (string, error)
+	RecordContext(ctx context.Context, live *Live, outputPath string) error
+}
+
+// Recorder is the interface for recording live streams.
+// The methods without a context are wrappers using context.Background().
 type Recorder interface {
+	ContextRecorder
 	GetLives() ([]*Live, error)
 	GetLive(url string) (*Live, error)
 	GetStreamingUrl(live *Live) (string, error)
 	Record(live *Live, outputPath string) error
 }
+
+// WatchListRecorder is implemented by platforms that cannot list every live stream
+// and instead discover lives by polling a configured list of accounts.
+type WatchListRecorder interface {
+	SetWatchList(usernames []string)
+}
+
+// PollLimiter is implemented by watch list recorders that limit how many accounts are checked
+// at once and the minimum delay between two requests
+type PollLimiter interface {
+	SetPollLimits(concurrency int, interval time.Duration)
+}
+
+// QualityResolver is implemented by recorders that choose the streaming url among several qualities,
+// returning the url together with the label of the quality chosen
+type QualityResolver interface {
+	GetStreamingUrlQualityContext(ctx context.Context, live *Live) (streamingUrl string, quality string, err error)
+}
+
+// ResolveStreamingUrl fetches a fresh streaming url of live and the label of its quality.
+// When rec does not tell the quality, the one of live is kept for the same url and cleared for another.
+func ResolveStreamingUrl(ctx context.Context, rec ContextRecorder, live *Live) (string, string, error) {
+	if resolver, ok := rec.(QualityResolver); ok {
+		return resolver.GetStreamingUrlQualityContext(ctx, live)
+	}
+	streamingUrl, err := rec.GetStreamingUrlContext(ctx, live)
+	if err != nil {
+		return "", "", err
+	}
+	if streamingUrl != live.StreamingUrl {
+		return streamingUrl, "", nil
+	}
+	return streamingUrl, live.Quality, nil
+}
+
+// PlatformLister is implemented by recorders that aggregate several platforms
+// and can report the lives, error and latency of each platform separately.
+type PlatformLister interface {
+	GetPlatformLivesContext(ctx context.Context) ([]*PlatformResult, error)
+}
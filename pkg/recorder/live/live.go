@@ -1,123 +1,201 @@
 package live
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
-	"github.com/agilistikmal/live-recorder/pkg/recorder/idn"
-	"github.com/agilistikmal/live-recorder/pkg/recorder/showroom"
-	"github.com/agilistikmal/live-recorder/pkg/recorder/tiktok"
 	"github.com/sirupsen/logrus"
+
+	// Built-in platforms register themselves on import
+	_ "github.com/agilistikmal/live-recorder/pkg/recorder/idn"
+	_ "github.com/agilistikmal/live-recorder/pkg/recorder/showroom"
+	_ "github.com/agilistikmal/live-recorder/pkg/recorder/tiktok"
 )
 
 type LiveRecorder struct {
-	showroomRecorder recorder.Recorder
-	idnRecorder      recorder.Recorder
-	tiktokRecorder   recorder.Recorder
-	liveQuery        *recorder.LiveQuery
+	recorders  map[string]recorder.Recorder
+	downloader recorder.Downloader
+	mu         sync.Mutex
+	liveQuery  *recorder.LiveQuery
 }
 
-func NewRecorder(liveQuery *recorder.LiveQuery) recorder.Recorder {
+func NewRecorder(liveQuery *recorder.LiveQuery) *LiveRecorder {
 	return &LiveRecorder{
-		showroomRecorder: showroom.NewRecorder(),
-		idnRecorder:      idn.NewRecorder(),
-		tiktokRecorder:   tiktok.NewRecorder(),
-		liveQuery:        liveQuery,
+		recorders: make(map[string]recorder.Recorder),
+		liveQuery: liveQuery,
+	}
+}
+
+// Platform returns the recorder registered for a platform, creating it on first use.
+func (s *LiveRecorder) Platform(platform string) (recorder.Recorder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, exists := s.recorders[platform]; exists {
+		return r, nil
+	}
+
+	r, err := recorder.New(platform)
+	if err != nil {
+		return nil, err
+	}
+	if setter, ok := r.(recorder.DownloaderSetter); ok && s.downloader != nil {
+		setter.SetDownloader(s.downloader)
+	}
+	s.recorders[platform] = r
+	return r, nil
+}
+
+// SetDownloader sets the backend used by every platform recorder that supports replacing it
+func (s *LiveRecorder) SetDownloader(downloader recorder.Downloader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.downloader = downloader
+	for _, r := range s.recorders {
+		if setter, ok := r.(recorder.DownloaderSetter); ok {
+			setter.SetDownloader(downloader)
+		}
 	}
 }
 
 func (s *LiveRecorder) GetLives() ([]*recorder.Live, error) {
+	return s.GetLivesContext(context.Background())
+}
+
+// GetLivesContext returns the filtered lives of all query platforms.
+// A failing platform is only logged, an error is returned when every platform failed.
+func (s *LiveRecorder) GetLivesContext(ctx context.Context) ([]*recorder.Live, error) {
+	results, err := s.GetPlatformLivesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	lives := make([]*recorder.Live, 0)
-	wg := sync.WaitGroup{}
-	for _, platform := range s.liveQuery.Platforms {
-		switch platform {
-		case recorder.PlatformShowroom:
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				showroomLives, err := s.showroomRecorder.GetLives()
-				if err != nil {
-					logrus.Errorf("Failed to get showroom lives: %v", err)
-					return
-				}
-				filteredShowroomLives, err := s.ApplyFilter(showroomLives, s.liveQuery)
-				if err != nil {
-					logrus.Errorf("Failed to apply filter to showroom lives: %v", err)
-					return
-				}
-				lives = append(lives, filteredShowroomLives...)
-			}()
-		case recorder.PlatformIDN:
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				idnLives, err := s.idnRecorder.GetLives()
-				if err != nil {
-					logrus.Errorf("Failed to get idn lives: %v", err)
-					return
-				}
-				filteredIdnLives, err := s.ApplyFilter(idnLives, s.liveQuery)
-				if err != nil {
-					logrus.Errorf("Failed to apply filter to idn lives: %v", err)
-					return
-				}
-				lives = append(lives, filteredIdnLives...)
-			}()
-		default:
+	errs := make([]error, 0)
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			logrus.Errorf("Failed to get %s lives: %v", result.Platform, result.Err)
+			errs = append(errs, fmt.Errorf("%s: %w", result.Platform, result.Err))
+			continue
+		}
+
+		// Remove duplicates
+		for _, live := range result.Lives {
+			key := live.Platform + ":" + live.ID
+			if !seen[key] {
+				seen[key] = true
+				lives = append(lives, live)
+			}
+		}
+	}
+
+	if len(results) > 0 && len(errs) == len(results) {
+		return nil, errors.Join(errs...)
+	}
+	return lives, nil
+}
+
+// GetPlatformLivesContext queries every platform of the query concurrently and reports
+// the filtered lives, the error and the latency of each one, in query order.
+func (s *LiveRecorder) GetPlatformLivesContext(ctx context.Context) ([]*recorder.PlatformResult, error) {
+	results := make([]*recorder.PlatformResult, len(s.liveQuery.Platforms))
+	platformRecorders := make([]recorder.Recorder, len(s.liveQuery.Platforms))
+	for i, platform := range s.liveQuery.Platforms {
+		platformRecorder, err := s.Platform(platform)
+		if err != nil {
 			logrus.Errorf("Invalid platform: %s", platform)
-			return nil, fmt.Errorf("invalid platform: %s", platform)
+			return nil, err
 		}
+		platformRecorders[i] = platformRecorder
+	}
+
+	// Each goroutine only writes its own slot, so no lock is needed
+	wg := sync.WaitGroup{}
+	for i, platform := range s.liveQuery.Platforms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := &recorder.PlatformResult{Platform: platform}
+			startedAt := time.Now()
+			platformLives, err := platformRecorders[i].GetLivesContext(ctx)
+			result.Latency = time.Since(startedAt)
+			if err == nil {
+				platformLives, err = s.ApplyFilter(platformLives, s.liveQuery)
+			}
+			result.Lives = platformLives
+			result.Err = err
+			results[i] = result
+		}()
 	}
 	wg.Wait()
 
-	// Remove duplicates
-	uniqueLives := make([]*recorder.Live, 0)
-	seen := make(map[string]bool)
-	for _, live := range lives {
-		if !seen[live.ID] {
-			seen[live.ID] = true
-			uniqueLives = append(uniqueLives, live)
-		}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	lives = uniqueLives
-	return lives, nil
+	return results, nil
 }
 
 func (s *LiveRecorder) GetLive(url string) (*recorder.Live, error) {
-	if len(s.liveQuery.Platforms) < 1 {
-		return nil, fmt.Errorf("no platforms provided")
+	return s.GetLiveContext(context.Background(), url)
+}
+
+// GetLiveContext resolves a single live URL using the platform detected from the URL itself.
+// The query platforms are not consulted, so a mismatching -p only produces a warning.
+func (s *LiveRecorder) GetLiveContext(ctx context.Context, url string) (*recorder.Live, error) {
+	platform, err := recorder.DetectPlatform(url)
+	if err != nil {
+		return nil, err
 	}
 
-	switch s.liveQuery.Platforms[0] {
-	case recorder.PlatformShowroom:
-		return s.showroomRecorder.GetLive(url)
-	case recorder.PlatformIDN:
-		return s.idnRecorder.GetLive(url)
-	case recorder.PlatformTiktok:
-		return s.tiktokRecorder.GetLive(url)
-	default:
-		return nil, fmt.Errorf("invalid platform: %s", s.liveQuery.Platforms[0])
+	if s.liveQuery != nil && len(s.liveQuery.Platforms) > 0 && !slices.Contains(s.liveQuery.Platforms, platform) {
+		logrus.Warnf("URL belongs to %s, ignoring platforms %v", platform, s.liveQuery.Platforms)
 	}
+
+	platformRecorder, err := s.Platform(platform)
+	if err != nil {
+		return nil, err
+	}
+	return platformRecorder.GetLiveContext(ctx, url)
 }
 
 func (s *LiveRecorder) GetStreamingUrl(live *recorder.Live) (string, error) {
-	switch live.Platform {
-	case recorder.PlatformShowroom:
-		return s.showroomRecorder.GetStreamingUrl(live)
-	default:
-		return live.StreamingUrl, nil
+	return s.GetStreamingUrlContext(context.Background(), live)
+}
+
+func (s *LiveRecorder) GetStreamingUrlContext(ctx context.Context, live *recorder.Live) (string, error) {
+	platformRecorder, err := s.Platform(live.Platform)
+	if err != nil {
+		return "", err
+	}
+	return platformRecorder.GetStreamingUrlContext(ctx, live)
+}
+
+func (s *LiveRecorder) GetStreamingUrlQualityContext(ctx context.Context, live *recorder.Live) (string, string, error) {
+	platformRecorder, err := s.Platform(live.Platform)
+	if err != nil {
+		return "", "", err
 	}
+	return recorder.ResolveStreamingUrl(ctx, platformRecorder, live)
 }
 
 func (s *LiveRecorder) Record(live *recorder.Live, outputPath string) error {
-	switch live.Platform {
-	case recorder.PlatformShowroom:
-		return s.showroomRecorder.Record(live, outputPath)
-	default:
-		return s.idnRecorder.Record(live, outputPath)
+	return s.RecordContext(context.Background(), live, outputPath)
+}
+
+func (s *LiveRecorder) RecordContext(ctx context.Context, live *recorder.Live, outputPath string) error {
+	platformRecorder, err := s.Platform(live.Platform)
+	if err != nil {
+		return err
 	}
+	return platformRecorder.RecordContext(ctx, live, outputPath)
 }
 
 func (s *LiveRecorder) ApplyFilter(lives []*recorder.Live, query *recorder.LiveQuery) ([]*recorder.Live, error) {
@@ -162,8 +240,15 @@ func (s *LiveRecorder) CheckFilters(live *recorder.Live, liveQuery *recorder.Liv
 		titleFilterPassed = titleFilterPassed || s.CheckWildcardFilter(liveTitleLower, liveQueryTitle)
 	}
 
-	// Return true if both filters passed
-	return streamerUsernameFilterPassed && titleFilterPassed
+	if !streamerUsernameFilterPassed || !titleFilterPassed {
+		return false
+	}
+
+	// Filter expression
+	if liveQuery.Filter != nil {
+		return liveQuery.Filter.Match(live)
+	}
+	return true
 }
 
 func (s *LiveRecorder) CheckWildcardFilter(text, filter string) bool {
@@ -0,0 +1,92 @@
+package recorder
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultProgressInterval is how often a download reports its progress when no interval is given
+const DefaultProgressInterval = 5 * time.Second
+
+// Progress is a snapshot of a running download
+type Progress struct {
+	URL        string        `json:"url"`
+	OutputPath string        `json:"output_path"` // File being written, which may be a temporary one
+	StartedAt  time.Time     `json:"started_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Elapsed    time.Duration `json:"elapsed"`  // Media time captured so far
+	Size       int64         `json:"size"`     // Bytes written so far
+	Segments   int           `json:"segments"` // Stream segments fetched so far, zero for streams without segments
+	Bitrate    float64       `json:"bitrate"`  // Bits per second of media captured since the previous report
+	Speed      float64       `json:"speed"`    // Media time captured per wall-clock time since the previous report, about 1 when keeping up
+}
+
+// ProgressFunc receives the progress of a download.
+// It is called from the downloading goroutine, so it should return quickly.
+type ProgressFunc func(progress Progress)
+
+type progressContextKey struct{}
+
+type progressReporter struct {
+	fn       ProgressFunc
+	interval time.Duration
+}
+
+// WithProgress returns a copy of ctx asking the downloads run with it to report their progress to fn
+// every interval, zero meaning DefaultProgressInterval
+func WithProgress(ctx context.Context, interval time.Duration, fn ProgressFunc) context.Context {
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	return context.WithValue(ctx, progressContextKey{}, &progressReporter{fn: fn, interval: interval})
+}
+
+// ProgressMeter turns the running totals of a download into periodic Progress reports.
+// A nil meter, returned when nobody asked for progress, ignores updates.
+type ProgressMeter struct {
+	reporter *progressReporter
+	progress Progress
+	reported time.Time // Time of the previous report, or the start
+}
+
+// NewProgressMeter returns a meter reporting to the ProgressFunc of ctx, or nil when ctx carries none
+func NewProgressMeter(ctx context.Context, url string, outputPath string) *ProgressMeter {
+	reporter, _ := ctx.Value(progressContextKey{}).(*progressReporter)
+	if reporter == nil || reporter.fn == nil {
+		return nil
+	}
+	now := time.Now()
+	return &ProgressMeter{
+		reporter: reporter,
+		progress: Progress{URL: url, OutputPath: outputPath, StartedAt: now},
+		reported: now,
+	}
+}
+
+// Update records the totals captured so far and reports them once the interval has passed since the previous report
+func (m *ProgressMeter) Update(elapsed time.Duration, size int64, segments int) {
+	if m == nil {
+		return
+	}
+
+	now := time.Now()
+	wall := now.Sub(m.reported)
+	if wall < m.reporter.interval {
+		return
+	}
+
+	media := elapsed - m.progress.Elapsed
+	bytes := size - m.progress.Size
+	m.progress.Bitrate = 0
+	if media > 0 {
+		m.progress.Bitrate = float64(bytes*8) / media.Seconds()
+	}
+	m.progress.Speed = media.Seconds() / wall.Seconds()
+	m.progress.Elapsed = elapsed
+	m.progress.Size = size
+	m.progress.Segments = segments
+	m.progress.UpdatedAt = now
+	m.reported = now
+
+	m.reporter.fn(m.progress)
+}
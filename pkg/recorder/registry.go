@@ -0,0 +1,86 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Factory creates a new Recorder for a platform
+type Factory func() Recorder
+
+// ErrUnsupportedURL is returned when a URL does not belong to any registered platform
+var ErrUnsupportedURL = errors.New("unsupported URL")
+
+var (
+	registryMu  sync.RWMutex
+	registry    = make(map[string]Factory)
+	urlPatterns = make(map[string][]*regexp.Regexp)
+)
+
+// Register makes a platform recorder available under the given name.
+// It is meant to be called from the init function of a platform package,
+// and panics if the factory is nil or the name is already registered.
+func Register(platform string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("recorder: Register factory is nil for platform " + platform)
+	}
+	if _, exists := registry[platform]; exists {
+		panic("recorder: Register called twice for platform " + platform)
+	}
+	registry[platform] = factory
+}
+
+// New creates a recorder for a registered platform
+func New(platform string) (Recorder, error) {
+	registryMu.RLock()
+	factory, exists := registry[platform]
+	registryMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("invalid platform: %s", platform)
+	}
+	return factory(), nil
+}
+
+// Platforms returns the sorted names of all registered platforms
+func Platforms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	platforms := make([]string, 0, len(registry))
+	for platform := range registry {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// RegisterURLPattern marks live URLs matching pattern as belonging to a platform,
+// so DetectPlatform can pick the right recorder for a single URL.
+func RegisterURLPattern(platform string, pattern *regexp.Regexp) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	urlPatterns[platform] = append(urlPatterns[platform], pattern)
+}
+
+// DetectPlatform returns the platform whose registered URL pattern matches url
+func DetectPlatform(url string) (string, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for platform, patterns := range urlPatterns {
+		for _, pattern := range patterns {
+			if pattern.MatchString(url) {
+				return platform, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedURL, url)
+}
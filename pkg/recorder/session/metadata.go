@@ -0,0 +1,160 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/sirupsen/logrus"
+)
+
+// Status of the file a sidecar describes
+const (
+	MetadataStatusRecording = "recording"
+	MetadataStatusCompleted = "completed"
+)
+
+// DefaultMetadataInterval is how often the sidecar of a file being recorded is refreshed
+const DefaultMetadataInterval = time.Minute
+
+// Metadata describes an output file, it is written next to it as <file>.json.
+// The sidecar is written as soon as a file starts recording and kept up to date,
+// so a recording that never finished still tells what it was.
+type Metadata struct {
+	Status       string         `json:"status"`
+	Live         *recorder.Live `json:"live"`
+	StreamingUrl string         `json:"streaming_url"` // Url the file was last downloaded from
+	Quality      string         `json:"quality,omitempty"`
+	Part         int            `json:"part"`  // Number of the file within the session, starting at 1
+	Parts        int            `json:"parts"` // Number of files of the session, 0 until it ends
+	StartedAt    time.Time      `json:"started_at"`
+	CompletedAt  *time.Time     `json:"completed_at,omitempty"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	Duration     time.Duration  `json:"duration"`
+	Size         int64          `json:"size"`
+	// Downloads are the attempts that went into the file, including those that captured nothing
+	Downloads []*Part  `json:"downloads"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// newMetadata sums up the downloads of a file
+func newMetadata(live *recorder.Live, part int, downloads []*Part) *Metadata {
+	now := time.Now()
+	m := &Metadata{
+		Status:       MetadataStatusRecording,
+		Live:         live,
+		StreamingUrl: live.StreamingUrl,
+		Quality:      live.Quality,
+		Part:         part,
+		StartedAt:    now,
+		UpdatedAt:    now,
+		Downloads:    downloads,
+	}
+	for i, download := range downloads {
+		if i == 0 {
+			m.StartedAt = download.Result.StartedAt
+		}
+		m.StreamingUrl = download.Result.URL
+		m.Quality = download.Quality
+		if download.Error != "" {
+			m.Errors = append(m.Errors, download.Error)
+		}
+		if download.Captured() {
+			m.Duration += download.Result.Duration
+			m.Size += download.Result.Size
+		}
+	}
+	return m
+}
+
+// MetadataPath returns the sidecar path of an output file
+func MetadataPath(outputPath string) string {
+	return outputPath + ".json"
+}
+
+// WriteMetadata writes the sidecar of outputPath, replacing it atomically
+func WriteMetadata(outputPath string, metadata *Metadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The sidecar of a file being recorded may be written before the downloader created its directory
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return err
+	}
+	tempPath := MetadataPath(outputPath) + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, MetadataPath(outputPath))
+}
+
+// ReadMetadata reads the sidecar of outputPath
+func ReadMetadata(outputPath string) (*Metadata, error) {
+	data, err := os.ReadFile(MetadataPath(outputPath))
+	if err != nil {
+		return nil, err
+	}
+	var metadata Metadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// sidecar keeps the metadata of the file being recorded up to date
+type sidecar struct {
+	outputPath string
+}
+
+// write writes the sidecar for outputPath, removing the one written for a previous guess of the path
+func (c *sidecar) write(outputPath string, metadata *Metadata) {
+	if c.outputPath != "" && c.outputPath != outputPath {
+		os.Remove(MetadataPath(c.outputPath))
+	}
+	c.outputPath = outputPath
+	if err := WriteMetadata(outputPath, metadata); err != nil {
+		logrus.Warnf("Failed to write metadata of %s: %v", outputPath, err)
+	}
+}
+
+// remove deletes the sidecar of a file that was never written
+func (c *sidecar) remove() {
+	if c.outputPath != "" {
+		os.Remove(MetadataPath(c.outputPath))
+		c.outputPath = ""
+	}
+}
+
+// refresh writes metadata for outputPath now and then every interval, counting the time
+// since it was called as recorded, until the returned function is called
+func (c *sidecar) refresh(outputPath string, metadata *Metadata, interval time.Duration) (stop func()) {
+	c.write(outputPath, metadata)
+
+	startedAt := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				updated := *metadata
+				updated.UpdatedAt = time.Now()
+				updated.Duration += time.Since(startedAt)
+				c.write(outputPath, &updated)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,275 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultRecoverMinAge     = time.Minute
+	DefaultRecoverSessionGap = 10 * time.Minute
+)
+
+// Temporary parts written by utils.DownloadHLS are named <output>.<unix timestamp>.tmp<ext>,
+// older versions named them <output>_<unix timestamp>.tmp<ext> and appended the timestamp to the output
+var (
+	tempPartPattern       = regexp.MustCompile(`^(.+)\.(\d+)\.tmp(\.[^.]+)$`)
+	legacyTempPartPattern = regexp.MustCompile(`^(.+)_(\d+)\.tmp(\.[^.]+)$`)
+)
+
+// RecoverOptions controls a recovery pass, nil means the defaults
+type RecoverOptions struct {
+	// MinAge skips files modified more recently, they may belong to a recording that is still running.
+	// Zero means DefaultRecoverMinAge, a negative value recovers every file.
+	MinAge time.Duration
+	// SessionGap is the longest gap between two parts of the same streamer that are still one session
+	SessionGap time.Duration
+	// DryRun only reports what would be recovered
+	DryRun bool
+}
+
+// RecoveredSession is a group of orphaned parts of one streamer recovered into a single file
+type RecoveredSession struct {
+	Name       string   `json:"name"` // Output path of the parts without the extension
+	Parts      []string `json:"parts"`
+	OutputPath string   `json:"output_path"`
+	Err        error    `json:"-"`
+}
+
+// RecoveryReport describes what a recovery pass found and did
+type RecoveryReport struct {
+	Sessions []*RecoveredSession `json:"sessions"`
+	Removed  []string            `json:"removed"` // Leftover concat lists and unfinished merges
+}
+
+// Failed returns the sessions that could not be recovered
+func (r *RecoveryReport) Failed() []*RecoveredSession {
+	var failed []*RecoveredSession
+	for _, s := range r.Sessions {
+		if s.Err != nil {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+type tempPart struct {
+	path       string
+	name       string
+	ext        string
+	outputPath string
+	startedAt  time.Time
+	endedAt    time.Time
+}
+
+// Recover finds the temporary parts left in dir by recordings that never finished,
+// for example when the process was killed, and remuxes them into playable files.
+// Parts of the same streamer close enough in time are joined into one file.
+func Recover(ctx context.Context, dir string, opts *RecoverOptions) (*RecoveryReport, error) {
+	o := RecoverOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MinAge == 0 {
+		o.MinAge = DefaultRecoverMinAge
+	} else if o.MinAge < 0 {
+		o.MinAge = 0
+	}
+	if o.SessionGap <= 0 {
+		o.SessionGap = DefaultRecoverSessionGap
+	}
+
+	report := &RecoveryReport{}
+	groups := make(map[string][]*tempPart)
+	cutoff := time.Now().Add(-o.MinAge)
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if isLeftover(path) {
+			if !o.DryRun {
+				os.Remove(path)
+			}
+			report.Removed = append(report.Removed, path)
+			return nil
+		}
+
+		legacy := false
+		matches := tempPartPattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			legacy = true
+			matches = legacyTempPartPattern.FindStringSubmatch(entry.Name())
+		}
+		if matches == nil {
+			return nil
+		}
+		timestamp, err := strconv.ParseInt(matches[2], 10, 64)
+		if err != nil {
+			return nil
+		}
+
+		name := filepath.Join(filepath.Dir(path), matches[1])
+		part := &tempPart{
+			path:       path,
+			name:       name,
+			ext:        matches[3],
+			outputPath: name + matches[3],
+			startedAt:  time.Unix(timestamp, 0),
+			endedAt:    info.ModTime(),
+		}
+		if legacy {
+			part.outputPath = fmt.Sprintf("%s_%d%s", name, timestamp, part.ext)
+		}
+		groups[name+part.ext] = append(groups[name+part.ext], part)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, parts := range splitSessions(groups[key], o.SessionGap) {
+			recovered := recoverParts(ctx, parts, o.DryRun)
+			if recovered.Err != nil {
+				logrus.Errorf("Failed to recover %d parts of %s: %v", len(recovered.Parts), recovered.Name, recovered.Err)
+			} else {
+				logrus.Infof("Recovered %d parts of %s into %s", len(recovered.Parts), recovered.Name, recovered.OutputPath)
+			}
+			report.Sessions = append(report.Sessions, recovered)
+		}
+	}
+	return report, nil
+}
+
+// isLeftover reports whether path is a concat list or an unfinished merge, whose inputs are still on disk
+func isLeftover(path string) bool {
+	ext := filepath.Ext(path)
+	if ext == ".list" {
+		return true
+	}
+	return filepath.Ext(path[:len(path)-len(ext)]) == ".joined"
+}
+
+// splitSessions orders parts by start time and splits them where the gap between two parts is too long
+func splitSessions(parts []*tempPart, maxGap time.Duration) [][]*tempPart {
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].startedAt.Before(parts[j].startedAt)
+	})
+
+	var sessions [][]*tempPart
+	for i, part := range parts {
+		if i == 0 || part.startedAt.Sub(parts[i-1].endedAt) > maxGap {
+			sessions = append(sessions, nil)
+		}
+		sessions[len(sessions)-1] = append(sessions[len(sessions)-1], part)
+	}
+	return sessions
+}
+
+// recoverParts remuxes the parts of one session into the file the recording would have produced
+func recoverParts(ctx context.Context, parts []*tempPart, dryRun bool) *RecoveredSession {
+	first := parts[0]
+	recovered := &RecoveredSession{
+		Name:       first.name,
+		OutputPath: first.outputPath,
+	}
+	for _, part := range parts {
+		recovered.Parts = append(recovered.Parts, part.path)
+	}
+	if dryRun {
+		return recovered
+	}
+
+	// The sidecar written while recording still describes the live
+	var mediaMetadata *utils.MediaMetadata
+	if metadata, err := ReadMetadata(recovered.OutputPath); err == nil {
+		mediaMetadata = utils.LiveMetadata(metadata.Live)
+	}
+	outputPath, err := utils.ReservePath(recovered.OutputPath)
+	if err != nil {
+		recovered.Err = err
+		return recovered
+	}
+	recovered.OutputPath = outputPath
+
+	used := recovered.Parts
+	err = utils.JoinFilesWithMetadata(ctx, recovered.Parts, recovered.OutputPath, mediaMetadata)
+	if err != nil {
+		// A part without its trailer makes the whole join fail, keep whatever can be read on its own
+		used, err = joinReadable(ctx, recovered.Parts, first.ext, recovered.OutputPath, mediaMetadata)
+	}
+	if err != nil {
+		os.Remove(recovered.OutputPath)
+		recovered.Err = err
+		return recovered
+	}
+	if len(used) < len(recovered.Parts) {
+		logrus.Warnf("Kept %d unreadable parts of %s in place", len(recovered.Parts)-len(used), first.name)
+	}
+
+	for _, part := range used {
+		os.Remove(part)
+	}
+	return recovered
+}
+
+// joinReadable remuxes every part on its own and joins those that could be read, returning them
+func joinReadable(ctx context.Context, parts []string, ext string, outputPath string, metadata *utils.MediaMetadata) ([]string, error) {
+	var errs []error
+	var readable, remuxed []string
+	for _, part := range parts {
+		partOutput := part + ".recovered" + ext
+		if err := utils.JoinFiles(ctx, []string{part}, partOutput); err != nil {
+			os.Remove(partOutput)
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(part), err))
+			continue
+		}
+		readable = append(readable, part)
+		remuxed = append(remuxed, partOutput)
+	}
+	defer func() {
+		for _, file := range remuxed {
+			os.Remove(file)
+		}
+	}()
+
+	if len(remuxed) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := utils.JoinFilesWithMetadata(ctx, remuxed, outputPath, metadata); err != nil {
+		return nil, err
+	}
+	return readable, nil
+}
@@ -0,0 +1,393 @@
+// Package session records a live stream across interruptions.
+// When a download stops while the same live is still on, recording resumes
+// as a new part of the same session and the parts are merged once the live ends.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/naming"
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultResumeDelay    = 5 * time.Second
+	DefaultMaxFailedParts = 3
+)
+
+// Options controls how a session resumes, nil means the defaults
+type Options struct {
+	// ResumeDelay is how long to wait after a drop before checking whether the live is still on
+	ResumeDelay time.Duration
+	// MaxFailedParts ends the session after this many consecutive attempts that captured nothing
+	MaxFailedParts int
+	// KeepParts skips merging and leaves every part as its own file
+	KeepParts bool
+	// Location is the timezone of the date and time fields of the output path, local time when nil
+	Location *time.Location
+	// MetadataInterval is how often the sidecar of the file being recorded is refreshed
+	MetadataInterval time.Duration
+}
+
+// Part is one uninterrupted download within a session
+type Part struct {
+	Result  *recorder.DownloadResult `json:"result"`
+	Gap     time.Duration            `json:"gap"` // Time missed since the previous part ended
+	Quality string                   `json:"quality,omitempty"`
+	Error   string                   `json:"error,omitempty"` // Error of Result, which is not serialized
+}
+
+// Captured reports whether the part wrote anything
+func (p *Part) Captured() bool {
+	return p.Result.OutputPath != "" && p.Result.Size > 0
+}
+
+// Session is a single live recorded in one or more parts.
+// Parts resumed after a drop are merged, a part ended by the downloader's split limit starts a new output file.
+type Session struct {
+	Live  *recorder.Live `json:"live"`
+	Parts []*Part        `json:"parts"`
+	// Outputs are the files the session produced, in order
+	Outputs []*recorder.DownloadResult `json:"outputs"`
+	// Result sums up all outputs, its Err is set only if nothing usable was recorded
+	Result *recorder.DownloadResult `json:"result"`
+}
+
+// Gaps returns the total time missed between parts
+func (s *Session) Gaps() time.Duration {
+	var total time.Duration
+	for _, part := range s.Parts {
+		total += part.Gap
+	}
+	return total
+}
+
+// SameLive reports whether two lives are the same session of a stream.
+// Platforms reusing the room ID as live ID are told apart by their start time.
+func SameLive(a, b *recorder.Live) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if a.Platform != b.Platform || a.ID != b.ID {
+		return false
+	}
+	if a.StartedAt != nil && b.StartedAt != nil {
+		return a.StartedAt.Unix() == b.StartedAt.Unix()
+	}
+	return true
+}
+
+// Record downloads live with d until the live ends or ctx is cancelled.
+// After every drop, rec is asked whether the same live is still on, and if so the
+// recording resumes with a fresh streaming url. The returned session is never nil.
+// outputPath is a naming template rendered for every output file, so it can
+// number the files of a split recording with {part}, see package naming.
+// Downloads report their progress to the recorder.ProgressFunc set on ctx with recorder.WithProgress.
+func Record(ctx context.Context, rec recorder.ContextRecorder, d recorder.Downloader, live *recorder.Live, outputPath string, opts *Options) *Session {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.ResumeDelay <= 0 {
+		o.ResumeDelay = DefaultResumeDelay
+	}
+	if o.MaxFailedParts < 1 {
+		o.MaxFailedParts = DefaultMaxFailedParts
+	}
+	if o.MetadataInterval <= 0 {
+		o.MetadataInterval = DefaultMetadataInterval
+	}
+
+	s := &Session{Live: live}
+	startedAt := time.Now()
+
+	template, err := naming.Parse(outputPath)
+	if err != nil {
+		s.Result = &recorder.DownloadResult{StartedAt: startedAt, CompletedAt: time.Now(), ExitCode: -1, Err: err}
+		return s
+	}
+	fields := naming.Fields{Live: live, Part: 1}
+
+	streamingUrl, quality := live.StreamingUrl, live.Quality
+	if streamingUrl == "" {
+		streamingUrl, quality, err = recorder.ResolveStreamingUrl(ctx, rec, live)
+		if err != nil {
+			s.Result = &recorder.DownloadResult{StartedAt: startedAt, CompletedAt: time.Now(), ExitCode: -1, Err: err}
+			return s
+		}
+	}
+
+	var lastErr error
+	failures := 0
+	split := false
+
+	// Downloads of the file being recorded, and where it ends up once one of them captured something
+	var current []*Part
+	currentPath := ""
+	metadata := &sidecar{}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			// A split is not a drop, the next file starts right away
+			if !split && !sleep(ctx, o.ResumeDelay) {
+				break
+			}
+
+			currentLive, err := rec.GetLiveContext(ctx, live.PlatformUrl)
+			if errors.Is(err, recorder.ErrNotLive) || (err == nil && !SameLive(live, currentLive)) {
+				logrus.Infof("Live %s of %s has ended", live.ID, streamerName(live))
+				break
+			}
+			if err == nil {
+				streamingUrl, quality, err = recorder.ResolveStreamingUrl(ctx, rec, currentLive)
+			}
+			if err != nil {
+				split = false
+				lastErr = err
+				failures++
+				logrus.Warnf("Failed to check whether live %s of %s is still on (%d/%d): %v",
+					live.ID, streamerName(live), failures, o.MaxFailedParts, err)
+				if failures >= o.MaxFailedParts {
+					break
+				}
+				continue
+			}
+			if split {
+				logrus.Infof("Live %s of %s reached the split limit, continuing in a new file", live.ID, streamerName(live))
+			} else {
+				logrus.Infof("Live %s of %s is still on, resuming recording as part %d", live.ID, streamerName(live), len(s.Parts)+1)
+			}
+		}
+
+		// A downloader that cannot read the stream would save whatever the server returns
+		if err := recorder.CheckProtocol(d, streamingUrl); err != nil {
+			logrus.Errorf("Cannot record live %s of %s: %v", live.ID, streamerName(live), err)
+			lastErr = err
+			break
+		}
+
+		// Parts resumed after a drop render the same name, the downloader numbers them to avoid a collision
+		fields.Time = time.Now()
+		if o.Location != nil {
+			fields.Time = fields.Time.In(o.Location)
+		}
+		renderedPath := template.Render(fields)
+
+		// Until a download captured something, the sidecar goes where the downloader reports it writes
+		progress := newMetadata(live, fields.Part, current)
+		progress.StreamingUrl, progress.Quality = streamingUrl, quality
+		stopRefresh := func() {}
+		startRefresh := func(outputPath string) {
+			stopRefresh()
+			stopRefresh = metadata.refresh(outputPath, progress, o.MetadataInterval)
+		}
+		downloadCtx := recorder.WithLive(ctx, live)
+		if currentPath != "" {
+			startRefresh(currentPath)
+		} else {
+			downloadCtx = recorder.WithOutputPath(downloadCtx, startRefresh)
+		}
+
+		part := &Part{Result: d.Download(downloadCtx, streamingUrl, renderedPath), Quality: quality}
+		stopRefresh()
+		if part.Result.Err != nil {
+			part.Error = part.Result.Err.Error()
+		}
+		if previous := lastCaptured(s.Parts); previous != nil && part.Captured() {
+			part.Gap = part.Result.StartedAt.Sub(previous.Result.CompletedAt)
+		}
+		s.Parts = append(s.Parts, part)
+		current = append(current, part)
+		if currentPath == "" && part.Captured() {
+			currentPath = part.Result.OutputPath
+		}
+		if currentPath != "" {
+			metadata.write(currentPath, newMetadata(live, fields.Part, current))
+		}
+
+		split = part.Result.Split
+		if split && part.Captured() {
+			// The finished file keeps its sidecar until finish completes it
+			fields.Part++
+			current, currentPath = nil, ""
+			metadata = &sidecar{}
+		}
+
+		if part.Captured() {
+			failures = 0
+		} else {
+			lastErr = part.Result.Err
+			failures++
+		}
+		if ctx.Err() != nil || failures >= o.MaxFailedParts {
+			break
+		}
+	}
+
+	if currentPath == "" {
+		metadata.remove()
+	}
+
+	s.Result = s.finish(startedAt, lastErr, o.KeepParts)
+	return s
+}
+
+// finish merges the captured parts between split points into one output each, completes their sidecars and sums them up
+func (s *Session) finish(startedAt time.Time, lastErr error, keepParts bool) *recorder.DownloadResult {
+	// Downloads of every output, including the attempts that captured nothing
+	var downloads [][]*Part
+	var group []*Part
+	flush := func() {
+		var captured []*Part
+		for _, part := range group {
+			if part.Captured() {
+				captured = append(captured, part)
+			}
+		}
+		if len(captured) > 0 {
+			outputs := s.merge(captured, keepParts)
+			for i := range outputs {
+				if len(outputs) > 1 {
+					downloads = append(downloads, captured[i:i+1])
+				} else {
+					downloads = append(downloads, group)
+				}
+			}
+			s.Outputs = append(s.Outputs, outputs...)
+		}
+		group = nil
+	}
+	for _, part := range s.Parts {
+		if part.Captured() && part.Gap > 0 && !part.Result.Split {
+			logrus.Infof("Recording of %s missed %v before %s", streamerName(s.Live), part.Gap.Round(time.Second), part.Result.OutputPath)
+		}
+		group = append(group, part)
+		if part.Captured() && part.Result.Split {
+			flush()
+		}
+	}
+	flush()
+
+	result := &recorder.DownloadResult{
+		StartedAt: startedAt,
+		ExitCode:  -1,
+	}
+	for i, output := range s.Outputs {
+		if i == 0 {
+			result.URL = output.URL
+			result.OutputPath = output.OutputPath
+		}
+		result.Size += output.Size
+		result.Duration += output.Duration
+		result.Segments += output.Segments
+		result.ExitCode = output.ExitCode
+
+		metadata := newMetadata(s.Live, i+1, downloads[i])
+		metadata.Status = MetadataStatusCompleted
+		metadata.Parts = len(s.Outputs)
+		metadata.CompletedAt = &output.CompletedAt
+		metadata.Duration = output.Duration
+		metadata.Size = output.Size
+		if err := WriteMetadata(output.OutputPath, metadata); err != nil {
+			logrus.Warnf("Failed to write metadata of %s: %v", output.OutputPath, err)
+		}
+	}
+	result.CompletedAt = time.Now()
+
+	if len(s.Outputs) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("nothing was recorded")
+		}
+		result.Err = lastErr
+	}
+	if len(s.Outputs) > 1 {
+		logrus.Infof("Recording of %s was saved in %d files", streamerName(s.Live), len(s.Outputs))
+	}
+	return result
+}
+
+// merge joins parts into the file of the first one and returns the resulting outputs,
+// which are the parts themselves when they are kept or cannot be joined
+func (s *Session) merge(parts []*Part, keepParts bool) []*recorder.DownloadResult {
+	results := make([]*recorder.DownloadResult, 0, len(parts))
+	files := make([]string, 0, len(parts))
+	for _, part := range parts {
+		results = append(results, part.Result)
+		files = append(files, part.Result.OutputPath)
+	}
+	if len(parts) == 1 || keepParts {
+		return results
+	}
+
+	// Join into a temporary file first, the first part is one of the inputs
+	ext := filepath.Ext(files[0])
+	joinedPath := strings.TrimSuffix(files[0], ext) + ".joined" + ext
+	err := utils.JoinFilesWithMetadata(context.Background(), files, joinedPath, utils.LiveMetadata(s.Live))
+	if err == nil {
+		err = os.Rename(joinedPath, files[0])
+	}
+	if err != nil {
+		os.Remove(joinedPath)
+		logrus.Errorf("Failed to merge %d parts of %s, keeping them as they are: %v", len(files), streamerName(s.Live), err)
+		return results
+	}
+	for _, file := range files[1:] {
+		os.Remove(file)
+	}
+
+	merged := *parts[0].Result
+	merged.Err = nil
+	for _, part := range parts[1:] {
+		merged.Size += part.Result.Size
+		merged.Duration += part.Result.Duration
+		merged.Segments += part.Result.Segments
+		merged.ExitCode = part.Result.ExitCode
+		merged.CompletedAt = part.Result.CompletedAt
+		merged.Split = part.Result.Split
+	}
+	if fileInfo, err := os.Stat(files[0]); err == nil {
+		merged.Size = fileInfo.Size()
+	}
+
+	var gaps time.Duration
+	for _, part := range parts[1:] {
+		gaps += part.Gap
+	}
+	logrus.Infof("Merged %d parts of %s into %s, %v missed in total", len(files), streamerName(s.Live), files[0], gaps.Round(time.Second))
+	return []*recorder.DownloadResult{&merged}
+}
+
+func lastCaptured(parts []*Part) *Part {
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i].Captured() {
+			return parts[i]
+		}
+	}
+	return nil
+}
+
+func streamerName(live *recorder.Live) string {
+	if live.Streamer == nil {
+		return fmt.Sprintf("%s/%s", live.Platform, live.ID)
+	}
+	return live.Streamer.Username
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
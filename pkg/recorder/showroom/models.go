@@ -43,10 +43,20 @@ type ShowroomStreamingUrl struct {
 	Url   string `json:"url"`
 }
 
+// preferredStreamingUrl returns the second entry of the list when there is one, the first otherwise
+func preferredStreamingUrl(list []ShowroomStreamingUrl) *ShowroomStreamingUrl {
+	switch len(list) {
+	case 0:
+		return nil
+	case 1:
+		return &list[0]
+	}
+	return &list[1]
+}
+
 // ToLive converts ShowroomLive to recorder.Live
 func (s *ShowroomLive) ToLive() *recorder.Live {
-	startedAt := time.Unix(int64(s.StartedAt), 0)
-	return &recorder.Live{
+	live := &recorder.Live{
 		ID: fmt.Sprintf("%v", s.RoomID),
 		Streamer: &recorder.LiveStreamer{
 			Username:      s.RoomUrlKey,
@@ -54,12 +64,70 @@ func (s *ShowroomLive) ToLive() *recorder.Live {
 			FollowerCount: s.FollowerNum,
 			ImageUrl:      s.ImageSquare,
 		},
-		Title:        s.Telop,
-		Platform:     recorder.PlatformShowroom,
-		PlatformUrl:  fmt.Sprintf("https://showroom-live.com/r/%v", s.RoomUrlKey),
-		StreamingUrl: s.StreamingUrlList[0].Url,
-		ImageUrl:     s.ImageSquare,
-		ViewCount:    s.ViewNum,
-		StartedAt:    &startedAt,
+		Title:       s.Telop,
+		Platform:    recorder.PlatformShowroom,
+		PlatformUrl: fmt.Sprintf("https://showroom-live.com/r/%v", s.RoomUrlKey),
+		ImageUrl:    s.ImageSquare,
+		ViewCount:   s.ViewNum,
+		StartedAt:   unixTime(s.StartedAt),
+	}
+	// The url and its label come from the entry GetStreamingUrlContext would choose
+	if streamingUrl := preferredStreamingUrl(s.StreamingUrlList); streamingUrl != nil {
+		live.StreamingUrl = streamingUrl.Url
+		live.Quality = streamingUrl.Label
+	}
+	return live
+}
+
+// ShowroomRoomStatus represents the room status response looked up by room URL key
+type ShowroomRoomStatus struct {
+	RoomID     int    `json:"room_id"`
+	RoomUrlKey string `json:"room_url_key"`
+	RoomName   string `json:"room_name"`
+	IsLive     bool   `json:"is_live"`
+}
+
+// ShowroomRoomProfile represents the room profile response
+type ShowroomRoomProfile struct {
+	RoomID               int    `json:"room_id"`
+	RoomUrlKey           string `json:"room_url_key"`
+	MainName             string `json:"main_name"`
+	FollowerNum          int    `json:"follower_num"`
+	ViewNum              int    `json:"view_num"`
+	ImageSquare          string `json:"image_square"`
+	IsOnLive             bool   `json:"is_onlive"`
+	CurrentLiveStartedAt int    `json:"current_live_started_at"`
+}
+
+// ShowroomTelop represents the telop (live title) response
+type ShowroomTelop struct {
+	Telop string `json:"telop"`
+}
+
+// ToLive converts ShowroomRoomProfile to recorder.Live without a streaming URL
+func (p *ShowroomRoomProfile) ToLive(telop string) *recorder.Live {
+	return &recorder.Live{
+		ID: fmt.Sprintf("%v", p.RoomID),
+		Streamer: &recorder.LiveStreamer{
+			Username:      p.RoomUrlKey,
+			Name:          p.MainName,
+			FollowerCount: p.FollowerNum,
+			ImageUrl:      p.ImageSquare,
+		},
+		Title:       telop,
+		Platform:    recorder.PlatformShowroom,
+		PlatformUrl: fmt.Sprintf("https://showroom-live.com/r/%v", p.RoomUrlKey),
+		ImageUrl:    p.ImageSquare,
+		ViewCount:   p.ViewNum,
+		StartedAt:   unixTime(p.CurrentLiveStartedAt),
+	}
+}
+
+// unixTime converts a unix timestamp of the API, nil when the API has none and returns 0
+func unixTime(timestamp int) *time.Time {
+	if timestamp == 0 {
+		return nil
 	}
+	t := time.Unix(int64(timestamp), 0)
+	return &t
 }
@@ -1,17 +1,30 @@
 package showroom
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	neturl "net/url"
+	"regexp"
 
+	"github.com/agilistikmal/live-recorder/pkg/downloader"
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
-	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/sirupsen/logrus"
 )
 
+// LiveURLPattern matches a Showroom room URL and captures the room URL key
+var LiveURLPattern = regexp.MustCompile(`^https?://(?:www\.)?showroom-live\.com/r/([\w-]+)/?(?:[?#].*)?$`)
+
+func init() {
+	recorder.Register(recorder.PlatformShowroom, NewRecorder)
+	recorder.RegisterURLPattern(recorder.PlatformShowroom, LiveURLPattern)
+}
+
 type ShowroomRecorder struct {
 	recorderConfig recorder.RecorderConfig
 	httpClient     *http.Client
+	downloader     recorder.Downloader
 }
 
 func NewRecorder() recorder.Recorder {
@@ -25,11 +38,16 @@ func NewRecorder() recorder.Recorder {
 	return &ShowroomRecorder{
 		recorderConfig: recorderConfig,
 		httpClient:     httpClient,
+		downloader:     downloader.Default(),
 	}
 }
 
 func (s *ShowroomRecorder) GetLives() ([]*recorder.Live, error) {
-	req, err := http.NewRequest("GET", "https://www.showroom-live.com/api/live/onlives", nil)
+	return s.GetLivesContext(context.Background())
+}
+
+func (s *ShowroomRecorder) GetLivesContext(ctx context.Context) ([]*recorder.Live, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.showroom-live.com/api/live/onlives", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -60,41 +78,146 @@ func (s *ShowroomRecorder) GetLives() ([]*recorder.Live, error) {
 }
 
 func (s *ShowroomRecorder) GetLive(url string) (*recorder.Live, error) {
-	return nil, nil
+	return s.GetLiveContext(context.Background(), url)
+}
+
+// GetLiveContext resolves a room URL (https://www.showroom-live.com/r/<room_url_key>) to its current live.
+// Returns a recorder.NotLiveError when the room is offline.
+func (s *ShowroomRecorder) GetLiveContext(ctx context.Context, url string) (*recorder.Live, error) {
+	matches := LiveURLPattern.FindStringSubmatch(url)
+	if len(matches) < 2 {
+		return nil, fmt.Errorf("invalid showroom room url: %s", url)
+	}
+	roomUrlKey := matches[1]
+
+	var roomStatus ShowroomRoomStatus
+	err := s.getJSON(ctx, fmt.Sprintf("https://www.showroom-live.com/api/room/status?room_url_key=%s", neturl.QueryEscape(roomUrlKey)), &roomStatus)
+	if err != nil {
+		return nil, err
+	}
+	if roomStatus.RoomID == 0 {
+		return nil, fmt.Errorf("showroom room not found: %s", roomUrlKey)
+	}
+	if !roomStatus.IsLive {
+		return nil, &recorder.NotLiveError{Platform: recorder.PlatformShowroom, Streamer: roomUrlKey}
+	}
+
+	var roomProfile ShowroomRoomProfile
+	err = s.getJSON(ctx, fmt.Sprintf("https://www.showroom-live.com/api/room/profile?room_id=%v", roomStatus.RoomID), &roomProfile)
+	if err != nil {
+		return nil, err
+	}
+	if roomProfile.RoomUrlKey == "" {
+		roomProfile.RoomUrlKey = roomUrlKey
+	}
+
+	// Telop is the live title, a missing one is not worth failing the lookup
+	var telop ShowroomTelop
+	err = s.getJSON(ctx, fmt.Sprintf("https://www.showroom-live.com/api/live/telop?room_id=%v", roomStatus.RoomID), &telop)
+	if err != nil {
+		logrus.Warnf("Failed to get showroom telop for %s: %v", roomUrlKey, err)
+	}
+
+	live := roomProfile.ToLive(telop.Telop)
+	streamingUrl, err := s.getStreamingUrl(ctx, live)
+	if err != nil {
+		return nil, err
+	}
+	live.StreamingUrl = streamingUrl.Url
+	live.Quality = streamingUrl.Label
+
+	return live, nil
 }
 
 func (s *ShowroomRecorder) GetStreamingUrl(live *recorder.Live) (string, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.showroom-live.com/api/live/streaming_url?abr_available=1&room_id=%v", live.ID), nil)
+	return s.GetStreamingUrlContext(context.Background(), live)
+}
+
+func (s *ShowroomRecorder) GetStreamingUrlContext(ctx context.Context, live *recorder.Live) (string, error) {
+	streamingUrl, err := s.getStreamingUrl(ctx, live)
 	if err != nil {
 		return "", err
 	}
+	return streamingUrl.Url, nil
+}
+
+// GetStreamingUrlQualityContext returns the streaming url chosen by GetStreamingUrlContext and its label
+func (s *ShowroomRecorder) GetStreamingUrlQualityContext(ctx context.Context, live *recorder.Live) (string, string, error) {
+	streamingUrl, err := s.getStreamingUrl(ctx, live)
+	if err != nil {
+		return "", "", err
+	}
+	return streamingUrl.Url, streamingUrl.Label, nil
+}
+
+// getStreamingUrl looks up the streaming urls of live and returns the preferred one
+func (s *ShowroomRecorder) getStreamingUrl(ctx context.Context, live *recorder.Live) (*ShowroomStreamingUrl, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://www.showroom-live.com/api/live/streaming_url?abr_available=1&room_id=%v", live.ID), nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("User-Agent", s.recorderConfig.UserAgent)
 	req.Header.Set("Referer", s.recorderConfig.Referer)
 	req.Header.Set("Cookie", s.recorderConfig.Cookie)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var srStreamingUrlResponses ShowroomStreamingUrlResponses
 	err = json.NewDecoder(resp.Body).Decode(&srStreamingUrlResponses)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	streamingUrl := preferredStreamingUrl(srStreamingUrlResponses.StreamingUrlList)
+	if streamingUrl == nil {
+		return nil, fmt.Errorf("showroom streaming url not found for room id: %s", live.ID)
+	}
+	return streamingUrl, nil
+}
+
+// getJSON sends a GET request with the recorder headers and decodes the JSON response into v
+func (s *ShowroomRecorder) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("User-Agent", s.recorderConfig.UserAgent)
+	req.Header.Set("Referer", s.recorderConfig.Referer)
+	req.Header.Set("Cookie", s.recorderConfig.Cookie)
 
-	if len(srStreamingUrlResponses.StreamingUrlList) < 1 {
-		return "", fmt.Errorf("showroom streaming url not found for room id: %s", live.ID)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	return srStreamingUrlResponses.StreamingUrlList[1].Url, nil
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("showroom api %s returned status %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
 }
 
 func (s *ShowroomRecorder) Record(live *recorder.Live, outputPath string) error {
-	downloadInfo := utils.DownloadHLS(live.StreamingUrl, &outputPath)
-	if downloadInfo == nil {
-		return fmt.Errorf("failed to download hls: %v", live.StreamingUrl)
+	return s.RecordContext(context.Background(), live, outputPath)
+}
+
+func (s *ShowroomRecorder) RecordContext(ctx context.Context, live *recorder.Live, outputPath string) error {
+	if err := recorder.CheckProtocol(s.downloader, live.StreamingUrl); err != nil {
+		return err
+	}
+	downloadResult := s.downloader.Download(recorder.WithLive(ctx, live), live.StreamingUrl, outputPath)
+	if downloadResult.Err != nil {
+		return fmt.Errorf("failed to download stream: %v: %w", live.StreamingUrl, downloadResult.Err)
 	}
 	return nil
 }
+
+// SetDownloader sets the backend used by Record
+func (s *ShowroomRecorder) SetDownloader(downloader recorder.Downloader) {
+	s.downloader = downloader
+}
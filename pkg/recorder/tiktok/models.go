@@ -1,5 +1,7 @@
 package tiktok
 
+import "fmt"
+
 // TiktokResponses represents the root response structure from TikTok API
 type TiktokResponses struct {
 	LiveRoom    TiktokLiveRoom    `json:"LiveRoom"`
@@ -157,3 +159,8 @@ type TiktokVideoQualityInfo struct {
 	VBitrate   int
 	Resolution [2]int
 }
+
+// String describes the quality as resolution and video bitrate, e.g. 1080x1920 2500kbps
+func (q TiktokVideoQualityInfo) String() string {
+	return fmt.Sprintf("%dx%d %dkbps", q.Resolution[0], q.Resolution[1], q.VBitrate/1000)
+}
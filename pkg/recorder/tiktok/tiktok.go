@@ -1,7 +1,9 @@
 package tiktok
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,15 +11,36 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/agilistikmal/live-recorder/pkg/downloader"
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
-	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// LiveURLPattern matches a TikTok live URL and captures the username
+var LiveURLPattern = regexp.MustCompile(`^https?://(?:www\.|m\.)?tiktok\.com/@([\w.-]+)/live/?(?:[?#].*)?$`)
+
+func init() {
+	recorder.Register(recorder.PlatformTiktok, NewRecorder)
+	recorder.RegisterURLPattern(recorder.PlatformTiktok, LiveURLPattern)
+}
+
+// Default limits used when polling the watch list
+const (
+	DefaultPollConcurrency = 4
+	DefaultPollInterval    = 500 * time.Millisecond
 )
 
 type TiktokRecorder struct {
-	recorderConfig recorder.RecorderConfig
-	httpClient     *http.Client
+	recorderConfig  recorder.RecorderConfig
+	httpClient      *http.Client
+	downloader      recorder.Downloader
+	mu              sync.RWMutex
+	watchList       []string
+	pollConcurrency int
+	pollInterval    time.Duration
 }
 
 func NewRecorder() recorder.Recorder {
@@ -28,19 +51,130 @@ func NewRecorder() recorder.Recorder {
 	}
 	httpClient := &http.Client{}
 	return &TiktokRecorder{
-		recorderConfig: recorderConfig,
-		httpClient:     httpClient,
+		recorderConfig:  recorderConfig,
+		httpClient:      httpClient,
+		downloader:      downloader.Default(),
+		pollConcurrency: DefaultPollConcurrency,
+		pollInterval:    DefaultPollInterval,
 	}
 }
 
+// SetWatchList sets the TikTok usernames polled by GetLives.
+// TikTok has no public listing of live streams, so only these accounts are discovered.
+func (s *TiktokRecorder) SetWatchList(usernames []string) {
+	watchList := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		if username != "" {
+			watchList = append(watchList, username)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchList = watchList
+}
+
+// SetPollLimits sets how many accounts are checked at once and the minimum delay between two requests.
+// Values lower than 1 and 0 fall back to the defaults.
+func (s *TiktokRecorder) SetPollLimits(concurrency int, interval time.Duration) {
+	if concurrency < 1 {
+		concurrency = DefaultPollConcurrency
+	}
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pollConcurrency = concurrency
+	s.pollInterval = interval
+}
+
 func (s *TiktokRecorder) GetLives() ([]*recorder.Live, error) {
+	return s.GetLivesContext(context.Background())
+}
+
+// GetLivesContext checks every account of the watch list and returns the ones that are live
+func (s *TiktokRecorder) GetLivesContext(ctx context.Context) ([]*recorder.Live, error) {
+	s.mu.RLock()
+	watchList := s.watchList
+	concurrency := s.pollConcurrency
+	interval := s.pollInterval
+	s.mu.RUnlock()
+
 	lives := make([]*recorder.Live, 0)
+	if len(watchList) == 0 {
+		return lives, nil
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		failures int
+		lastErr  error
+	)
+	sem := make(chan struct{}, concurrency)
+	limiter := time.NewTicker(interval)
+	defer limiter.Stop()
+
+	for i, username := range watchList {
+		// First request goes out immediately, the rest wait for the limiter
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-limiter.C:
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Every slot may be held by a request that takes long to answer
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(username string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			live, err := s.GetLiveContext(ctx, fmt.Sprintf("https://www.tiktok.com/@%s/live", username))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if !errors.Is(err, recorder.ErrNotLive) {
+					logrus.Warnf("Failed to check tiktok live for %s: %v", username, err)
+					failures++
+					lastErr = err
+				}
+				return
+			}
+			lives = append(lives, live)
+		}(username)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if failures == len(watchList) {
+		return nil, fmt.Errorf("failed to check all %d tiktok accounts: %w", failures, lastErr)
+	}
 
 	return lives, nil
 }
 
 func (s *TiktokRecorder) GetLive(url string) (*recorder.Live, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return s.GetLiveContext(context.Background(), url)
+}
+
+func (s *TiktokRecorder) GetLiveContext(ctx context.Context, url string) (*recorder.Live, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +210,7 @@ func (s *TiktokRecorder) GetLive(url string) (*recorder.Live, error) {
 	startedAt := time.Unix(liveRoom.LiveRoom.StartTime, 0)
 
 	if user.Status != 2 {
-		return nil, fmt.Errorf("user is not live")
+		return nil, &recorder.NotLiveError{Platform: recorder.PlatformTiktok, Streamer: user.UniqueId}
 	}
 
 	live := &recorder.Live{
@@ -94,28 +228,64 @@ func (s *TiktokRecorder) GetLive(url string) (*recorder.Live, error) {
 		},
 	}
 
+	// Prefer HLS, fall back to FLV for downloaders that cannot read HLS
+	protocol := recorder.ProtocolHLS
+	if !s.getDownloader().Capabilities().Supports(recorder.ProtocolHLS) {
+		protocol = recorder.ProtocolFLV
+	}
+
 	streamDataStr := liveRoom.LiveRoom.StreamData.PullData.StreamData
-	m3u8UrlList, err := getVideoQualityUrl(streamDataStr, "hls")
+	urlList, err := getVideoQualityUrl(streamDataStr, protocol)
 	if err != nil {
 		return nil, err
 	}
-	live.StreamingUrl = m3u8UrlList[0].URL
+	if len(urlList) < 1 {
+		return nil, fmt.Errorf("tiktok %s url not found for %s", protocol, user.UniqueId)
+	}
+	live.StreamingUrl = urlList[0].URL
+	live.Quality = urlList[0].String()
 
 	return live, nil
 }
 
 func (s *TiktokRecorder) GetStreamingUrl(live *recorder.Live) (string, error) {
+	return s.GetStreamingUrlContext(context.Background(), live)
+}
+
+func (s *TiktokRecorder) GetStreamingUrlContext(ctx context.Context, live *recorder.Live) (string, error) {
 	return live.StreamingUrl, nil
 }
 
 func (s *TiktokRecorder) Record(live *recorder.Live, outputPath string) error {
-	downloadInfo := utils.DownloadHLS(live.StreamingUrl, &outputPath)
-	if downloadInfo == nil {
-		return fmt.Errorf("failed to download hls: %v", live.StreamingUrl)
+	return s.RecordContext(context.Background(), live, outputPath)
+}
+
+func (s *TiktokRecorder) RecordContext(ctx context.Context, live *recorder.Live, outputPath string) error {
+	d := s.getDownloader()
+	if err := recorder.CheckProtocol(d, live.StreamingUrl); err != nil {
+		return err
+	}
+	downloadResult := d.Download(recorder.WithLive(ctx, live), live.StreamingUrl, outputPath)
+	if downloadResult.Err != nil {
+		return fmt.Errorf("failed to download stream: %v: %w", live.StreamingUrl, downloadResult.Err)
 	}
 	return nil
 }
 
+// SetDownloader sets the backend used by Record
+func (s *TiktokRecorder) SetDownloader(downloader recorder.Downloader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downloader = downloader
+}
+
+// getDownloader returns the backend, which watch list pollers read while it may be replaced
+func (s *TiktokRecorder) getDownloader() recorder.Downloader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.downloader
+}
+
 // qKey: hls, flv
 func getVideoQualityUrl(streamDataStr string, qKey string) ([]TiktokVideoQualityInfo, error) {
 	var streamDataMap map[string]any
@@ -0,0 +1,273 @@
+package watch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrStoreLocked is returned when another process has the store open for writing
+	ErrStoreLocked = errors.New("state file is in use by another process")
+	// ErrReadOnly is returned when saving to a store opened with LoadFileStore
+	ErrReadOnly = errors.New("state file is opened read-only")
+)
+
+// FileStore is a Store kept in a single JSON lines file.
+// Every save appends the record, the file is compacted to one line per record when it is opened for writing.
+// A single process writes to the file, it holds <path>.lock until the store is closed.
+type FileStore struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	readOnly bool
+	records  map[string]*Record
+	order    []string // Record IDs in the order they were first saved
+}
+
+// NewFileStore opens the store at path for writing, creating it if it does not exist.
+// It fails with ErrStoreLocked while another process has it open for writing.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, records: make(map[string]*Record)}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := s.lock(); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		s.unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadFileStore reads the store at path without writing to it, so it can be queried
+// while another process records to it. A missing file is an empty store.
+func LoadFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, readOnly: true, records: make(map[string]*Record)}
+	if err := s.read(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open reads and compacts the file, then opens it for appending, the lock must be held
+func (s *FileStore) open() error {
+	if err := s.read(); err != nil {
+		return err
+	}
+	if err := s.compact(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+func (s *FileStore) lockPath() string {
+	return s.path + ".lock"
+}
+
+// lock creates the lock file holding the pid of this process,
+// taking over the lock of a process that is no longer running
+func (s *FileStore) lock() error {
+	for {
+		file, err := os.OpenFile(s.lockPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(file, "%d\n", os.Getpid())
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				s.unlock()
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		pid, running := lockOwner(s.lockPath())
+		if running {
+			return fmt.Errorf("%w (pid %d), remove %s if it is not running", ErrStoreLocked, pid, s.lockPath())
+		}
+		logrus.Warnf("Taking over %s, process %d holding it is not running", s.lockPath(), pid)
+		if err := os.Remove(s.lockPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+}
+
+func (s *FileStore) unlock() {
+	os.Remove(s.lockPath())
+}
+
+// lockOwner returns the pid written in a lock file and whether that process is still running.
+// A lock of this process is left over by a previous run that got the same pid, as in a container.
+func lockOwner(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 || pid == os.Getpid() {
+		return pid, false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return pid, false
+	}
+	// Signal 0 only checks the process exists, platforms without signals report it as running
+	return pid, !errors.Is(process.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// read loads the records of the file, the last line of a record wins
+func (s *FileStore) read() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.ID == "" {
+			// A line cut short by a crash while it was written
+			logrus.Warnf("Skipping unreadable line %d of %s: %v", line, s.path, err)
+			continue
+		}
+		s.put(&r)
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with one line per record, replacing it atomically, the lock must be held
+func (s *FileStore) compact() error {
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, id := range s.order {
+		if err = encoder.Encode(s.records[id]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, s.path)
+}
+
+// stale reports whether a more recent save of r is kept, saves may race to the store
+func (s *FileStore) stale(r *Record) bool {
+	existing, exists := s.records[r.ID]
+	return exists && existing.UpdatedAt.After(r.UpdatedAt)
+}
+
+func (s *FileStore) put(r *Record) {
+	if s.stale(r) {
+		return
+	}
+	if _, exists := s.records[r.ID]; !exists {
+		s.order = append(s.order, r.ID)
+	}
+	s.records[r.ID] = r
+}
+
+// Save appends the record to the file
+func (s *FileStore) Save(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if s.stale(record) {
+		return nil
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.put(record)
+	return nil
+}
+
+// Load returns every record, oldest first
+func (s *FileStore) Load() ([]*Record, error) {
+	s.mu.Lock()
+	records := make([]*Record, 0, len(s.order))
+	for _, id := range s.order {
+		records = append(records, s.records[id])
+	}
+	s.mu.Unlock()
+
+	slices.SortStableFunc(records, func(a, b *Record) int {
+		return a.Time().Compare(b.Time())
+	})
+	return records, nil
+}
+
+// Query returns the records matching q, most recent first
+func (s *FileStore) Query(q *HistoryQuery) ([]*Record, error) {
+	records, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	return q.Select(records), nil
+}
+
+// Close closes the file and releases the lock, saving afterwards fails
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	s.unlock()
+	return err
+}
@@ -1,34 +1,104 @@
 package watch
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
 )
 
 // RecordingStatus represents the status of a recording
 type RecordingStatus string
 
 const (
+	StatusQueued     RecordingStatus = "queued" // Waiting for a free slot under the concurrency limits
 	StatusInProgress RecordingStatus = "in_progress"
+	StatusRetrying   RecordingStatus = "retrying" // Failed and waiting to start again, see RetryPolicy
 	StatusCompleted  RecordingStatus = "completed"
 	StatusFailed     RecordingStatus = "failed"
 )
 
+// RecordingKey identifies a recording by platform, streamer and live session,
+// so the same username on two platforms and two lives of one streamer are told apart
+type RecordingKey struct {
+	Platform string `json:"platform"`
+	Streamer string `json:"streamer"` // Username, or the live ID for a live without a streamer
+	Live     string `json:"live"`     // Live ID, followed by the start time for platforms reusing the ID
+}
+
+// NewRecordingKey returns the key of the recording of live
+func NewRecordingKey(live *recorder.Live) RecordingKey {
+	key := RecordingKey{Platform: live.Platform, Streamer: live.ID, Live: live.ID}
+	if live.Streamer != nil && live.Streamer.Username != "" {
+		key.Streamer = live.Streamer.Username
+	}
+	if live.StartedAt != nil {
+		key.Live = fmt.Sprintf("%s@%d", live.ID, live.StartedAt.Unix())
+	}
+	return key
+}
+
+// String returns the key as platform/streamer/live
+func (k RecordingKey) String() string {
+	return k.Platform + "/" + k.Streamer + "/" + k.Live
+}
+
+// StreamerID returns the key of the streamer as platform/streamer
+func (k RecordingKey) StreamerID() string {
+	return k.Platform + "/" + k.Streamer
+}
+
+// matches reports whether id is the key itself, the key of its streamer or the bare streamer name
+func (k RecordingKey) matches(id string) bool {
+	return id == k.String() || id == k.StreamerID() || id == k.Streamer
+}
+
 // RecordingInfo contains information about a recording
 type RecordingInfo struct {
+	Key         RecordingKey
 	Live        *recorder.Live
 	Status      RecordingStatus
-	StartedAt   time.Time
+	QueuedAt    time.Time
+	StartedAt   time.Time // Zero while queued
 	CompletedAt *time.Time
-	FilePath    string
-	FileSize    int64
+	Parts       []*recorder.DownloadResult // Output files, more than one when the recording was split
+	FileSize    int64                      // Total size of all parts
+	Result      *recorder.DownloadResult
+	Session     *session.Session
+	Progress    *recorder.Progress // Latest progress of the running download, nil until one is reported
+	Preempted   bool               // Stopped to free a slot for a live ranking higher, the live was queued again
+	Attempts    []*Attempt         // Tries at recording the live, the last one is running unless the recording finished
+	RetryAt     *time.Time         // When the next attempt starts, set while retrying
 	Error       error
+
+	cancel context.CancelFunc // Stops the recording, set while it runs
 }
 
-// StatusUpdate represents a status update event
+// StatusUpdate represents a status update event.
+// StreamerID is the recording key as platform/streamer/live, see RecordingKey.
+// Progress is set on the periodic updates of a recording in progress, which keep the status unchanged.
 type StatusUpdate struct {
 	StreamerID string
 	Status     RecordingStatus
 	Info       *RecordingInfo
+	Progress   *recorder.Progress
+}
+
+// FilePaths returns the paths of the output files in order
+func (ri *RecordingInfo) FilePaths() []string {
+	paths := make([]string, 0, len(ri.Parts))
+	for _, part := range ri.Parts {
+		paths = append(paths, part.OutputPath)
+	}
+	return paths
+}
+
+// Duration returns the media duration of the recording, zero while it is in progress
+func (ri *RecordingInfo) Duration() time.Duration {
+	if ri.Result == nil {
+		return 0
+	}
+	return ri.Result.Duration
 }
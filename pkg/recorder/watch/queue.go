@@ -0,0 +1,190 @@
+package watch
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/sirupsen/logrus"
+)
+
+// Limits caps how many recordings run at the same time, lives over a limit are queued.
+// Zero means no limit.
+type Limits struct {
+	// Max is the number of recordings running at the same time over all platforms
+	Max int
+	// PerPlatform is the number of recordings running at the same time on a platform
+	PerPlatform map[string]int
+	// Preempt lets a queued live stop the lowest ranked running recording that holds its slot,
+	// when the queued live ranks strictly higher. The stopped recording keeps what it captured
+	// and is queued again, it resumes in a new file once a slot is free.
+	Preempt bool
+}
+
+// Priority ranks two lives like cmp.Compare, a live comparing greater is recorded first
+type Priority func(a, b *recorder.Live) int
+
+// ByStreamers ranks the streamers of the list above everyone else, in the order of the list.
+// Entries are usernames, or platform/username to match a single platform.
+func ByStreamers(streamers []string) Priority {
+	rank := make(map[string]int, len(streamers))
+	for i, streamer := range streamers {
+		rank[strings.ToLower(strings.TrimSpace(streamer))] = len(streamers) - i
+	}
+	rankOf := func(live *recorder.Live) int {
+		key := NewRecordingKey(live)
+		if r, ok := rank[strings.ToLower(key.StreamerID())]; ok {
+			return r
+		}
+		return rank[strings.ToLower(key.Streamer)]
+	}
+	return func(a, b *recorder.Live) int {
+		return cmp.Compare(rankOf(a), rankOf(b))
+	}
+}
+
+// ByFollowers ranks streamers with more followers first
+func ByFollowers(a, b *recorder.Live) int {
+	return cmp.Compare(followers(a), followers(b))
+}
+
+// ByViews ranks lives with more viewers first
+func ByViews(a, b *recorder.Live) int {
+	return cmp.Compare(a.ViewCount, b.ViewCount)
+}
+
+// Chain ranks by the first priority telling two lives apart
+func Chain(priorities ...Priority) Priority {
+	return func(a, b *recorder.Live) int {
+		for _, priority := range priorities {
+			if c := priority(a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
+
+func followers(live *recorder.Live) int {
+	if live.Streamer == nil {
+		return 0
+	}
+	return live.Streamer.FollowerCount
+}
+
+// compare ranks two recordings, a queued live waiting longer wins a tie
+func (ws *WatchLive) compare(a, b *RecordingInfo) int {
+	if ws.priority != nil {
+		if c := ws.priority(a.Live, b.Live); c != 0 {
+			return c
+		}
+	}
+	return b.QueuedAt.Compare(a.QueuedAt)
+}
+
+// running returns the number of running recordings over all platforms and on platform, ws.mu must be held
+func (ws *WatchLive) running(platform string) (total int, onPlatform int) {
+	for _, info := range ws.recordings {
+		if info.Status != StatusQueued {
+			total++
+			if info.Key.Platform == platform {
+				onPlatform++
+			}
+		}
+	}
+	return total, onPlatform
+}
+
+// fits reports whether a recording on platform can start now, and if not whether the platform limit is the one reached.
+// ws.mu must be held.
+func (ws *WatchLive) fits(platform string) (ok bool, platformFull bool) {
+	if ws.limits == nil {
+		return true, false
+	}
+	total, onPlatform := ws.running(platform)
+	if limit := ws.limits.PerPlatform[platform]; limit > 0 && onPlatform >= limit {
+		return false, true
+	}
+	if ws.limits.Max > 0 && total >= ws.limits.Max {
+		return false, false
+	}
+	return true, false
+}
+
+// queued returns the queued recordings, highest ranked first, ws.mu must be held
+func (ws *WatchLive) queued() []*RecordingInfo {
+	var queue []*RecordingInfo
+	for _, info := range ws.recordings {
+		if info.Status == StatusQueued {
+			queue = append(queue, info)
+		}
+	}
+	slices.SortFunc(queue, func(a, b *RecordingInfo) int {
+		return ws.compare(b, a)
+	})
+	return queue
+}
+
+// nextQueued returns the highest ranked queued recording that fits the limits, ws.mu must be held
+func (ws *WatchLive) nextQueued() *RecordingInfo {
+	for _, info := range ws.queued() {
+		if ok, _ := ws.fits(info.Key.Platform); ok {
+			return info
+		}
+	}
+	return nil
+}
+
+// preempt stops the lowest ranked running recording holding the slot the highest ranked queued live needs,
+// if that live ranks strictly higher. One recording is preempted at a time. ws.mu must be held.
+func (ws *WatchLive) preempt() {
+	if ws.limits == nil || !ws.limits.Preempt {
+		return
+	}
+	var victim *RecordingInfo
+	for _, info := range ws.recordings {
+		if info.Status == StatusInProgress && info.Preempted {
+			return
+		}
+	}
+
+	queue := ws.queued()
+	if len(queue) == 0 {
+		return
+	}
+	candidate := queue[0]
+	_, platformFull := ws.fits(candidate.Key.Platform)
+	for _, info := range ws.recordings {
+		if info.Status != StatusInProgress || info.cancel == nil {
+			continue
+		}
+		if platformFull && info.Key.Platform != candidate.Key.Platform {
+			continue
+		}
+		// The lowest ranked, and of those the most recently started, loses the least
+		if victim == nil || ws.compare(info, victim) < 0 || (ws.compare(info, victim) == 0 && info.StartedAt.After(victim.StartedAt)) {
+			victim = info
+		}
+	}
+	if victim == nil || ws.priority == nil || ws.priority(candidate.Live, victim.Live) <= 0 {
+		return
+	}
+
+	logrus.Infof("Stopping the recording of %s for %s, which ranks higher", victim.Key, candidate.Key)
+	victim.Preempted = true
+	victim.cancel()
+}
+
+// requeue queues a preempted live again so it resumes once a slot is free, ws.mu must be held
+func (ws *WatchLive) requeue(preempted *RecordingInfo) *RecordingInfo {
+	info := &RecordingInfo{
+		Key:      preempted.Key,
+		Live:     preempted.Live,
+		Status:   StatusQueued,
+		QueuedAt: time.Now(),
+	}
+	ws.recordings[info.Key.String()] = info
+	return info
+}
@@ -0,0 +1,96 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
+)
+
+const (
+	DefaultMaxRetries      = 3
+	DefaultRetryDelay      = 10 * time.Second
+	DefaultRetryMaxDelay   = 5 * time.Minute
+	DefaultRetryMultiplier = 2
+)
+
+// RetryPolicy controls how a failed recording is started again, nil means the defaults.
+// Before every retry the live is checked to still be on and a fresh streaming url is fetched.
+type RetryPolicy struct {
+	// MaxRetries is how many times a failed recording is started again, a negative value disables retrying
+	MaxRetries int
+	// Delay is the wait before the first retry
+	Delay time.Duration
+	// MaxDelay caps the wait between retries
+	MaxDelay time.Duration
+	// Multiplier grows the wait after every retry
+	Multiplier float64
+}
+
+// Attempt is one try at recording a live
+type Attempt struct {
+	Number      int
+	StartedAt   time.Time
+	CompletedAt time.Time
+	Err         error // Nil for the attempt that recorded the live
+}
+
+// withDefaults returns a copy of p with the unset fields filled in
+func (p *RetryPolicy) withDefaults() RetryPolicy {
+	policy := RetryPolicy{}
+	if p != nil {
+		policy = *p
+	}
+	if policy.MaxRetries == 0 {
+		policy.MaxRetries = DefaultMaxRetries
+	}
+	if policy.Delay <= 0 {
+		policy.Delay = DefaultRetryDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultRetryMaxDelay
+	}
+	if policy.MaxDelay < policy.Delay {
+		policy.MaxDelay = policy.Delay
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = DefaultRetryMultiplier
+	}
+	return policy
+}
+
+// backoff returns the wait before the given retry, starting at 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.Delay)
+	for i := 1; i < retry && delay < float64(p.MaxDelay); i++ {
+		delay *= p.Multiplier
+	}
+	return min(time.Duration(delay), p.MaxDelay)
+}
+
+// stillLive reports whether live is still on, returning the current view of it.
+// An error other than the live being over is returned as is, so it counts as a failed attempt.
+func (ws *WatchLive) stillLive(ctx context.Context, live *recorder.Live) (*recorder.Live, bool, error) {
+	current, err := ws.liveRecorder.GetLiveContext(ctx, live.PlatformUrl)
+	if errors.Is(err, recorder.ErrNotLive) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return current, session.SameLive(live, current), nil
+}
+
+// sleep waits for d, returning false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,273 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInterrupted is the error of a recording that was still running when the previous run stopped
+var ErrInterrupted = errors.New("recording interrupted by a restart")
+
+// Store persists the recordings of a WatchLive, so a restart keeps the history
+// and does not record a finished live again. See FileStore.
+type Store interface {
+	// Save inserts the record, or replaces the one with the same ID
+	Save(record *Record) error
+	// Load returns every record, oldest first
+	Load() ([]*Record, error)
+	// Query returns the records matching q, most recent first
+	Query(q *HistoryQuery) ([]*Record, error)
+}
+
+// Record is the stored form of a RecordingInfo
+type Record struct {
+	ID          string                     `json:"id"` // Recording key and queue time, a live queued again after a preemption is a new record
+	Key         RecordingKey               `json:"key"`
+	Live        *recorder.Live             `json:"live"`
+	Status      RecordingStatus            `json:"status"`
+	QueuedAt    time.Time                  `json:"queued_at"`
+	StartedAt   time.Time                  `json:"started_at"`
+	CompletedAt *time.Time                 `json:"completed_at,omitempty"`
+	UpdatedAt   time.Time                  `json:"updated_at"`
+	Outputs     []*recorder.DownloadResult `json:"outputs,omitempty"`
+	Result      *recorder.DownloadResult   `json:"result,omitempty"`
+	Attempts    []*AttemptRecord           `json:"attempts,omitempty"`
+	Preempted   bool                       `json:"preempted,omitempty"`
+	Error       string                     `json:"error,omitempty"`
+}
+
+// AttemptRecord is the stored form of an Attempt
+type AttemptRecord struct {
+	Number      int       `json:"number"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Time returns when the recording started, or when it was queued if it never started
+func (r *Record) Time() time.Time {
+	if r.StartedAt.IsZero() {
+		return r.QueuedAt
+	}
+	return r.StartedAt
+}
+
+// newRecord returns the stored form of info, ws.mu must be held
+func newRecord(info *RecordingInfo) *Record {
+	r := &Record{
+		ID:          fmt.Sprintf("%s#%d", info.Key, info.QueuedAt.UnixNano()),
+		Key:         info.Key,
+		Live:        info.Live,
+		Status:      info.Status,
+		QueuedAt:    info.QueuedAt,
+		StartedAt:   info.StartedAt,
+		CompletedAt: info.CompletedAt,
+		UpdatedAt:   time.Now(),
+		Outputs:     info.Parts,
+		Result:      info.Result,
+		Preempted:   info.Preempted,
+		Error:       errorString(info.Error),
+	}
+	for _, attempt := range info.Attempts {
+		r.Attempts = append(r.Attempts, &AttemptRecord{
+			Number:      attempt.Number,
+			StartedAt:   attempt.StartedAt,
+			CompletedAt: attempt.CompletedAt,
+			Error:       errorString(attempt.Err),
+		})
+	}
+	return r
+}
+
+// Info returns the recording the record was saved from. Errors come back as plain errors
+// with the same message, and the session of the recording is not kept.
+func (r *Record) Info() *RecordingInfo {
+	info := &RecordingInfo{
+		Key:         r.Key,
+		Live:        r.Live,
+		Status:      r.Status,
+		QueuedAt:    r.QueuedAt,
+		StartedAt:   r.StartedAt,
+		CompletedAt: r.CompletedAt,
+		Parts:       r.Outputs,
+		Result:      r.Result,
+		Preempted:   r.Preempted,
+		Error:       stringError(r.Error),
+	}
+	for _, output := range r.Outputs {
+		info.FileSize += output.Size
+	}
+	for _, attempt := range r.Attempts {
+		info.Attempts = append(info.Attempts, &Attempt{
+			Number:      attempt.Number,
+			StartedAt:   attempt.StartedAt,
+			CompletedAt: attempt.CompletedAt,
+			Err:         stringError(attempt.Error),
+		})
+	}
+	return info
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func stringError(s string) error {
+	switch s {
+	case "":
+		return nil
+	case ErrInterrupted.Error():
+		return ErrInterrupted
+	}
+	return errors.New(s)
+}
+
+// HistoryQuery selects recordings, zero fields match everything
+type HistoryQuery struct {
+	// Streamer is a username, platform/username or a recording key, as accepted by GetStatus
+	Streamer string
+	Platform string
+	Status   RecordingStatus
+	// Since and Until bound the time a recording started, or was queued if it never started
+	Since time.Time
+	Until time.Time
+	// Limit keeps only the most recent recordings
+	Limit int
+}
+
+// Match reports whether r is selected by q
+func (q *HistoryQuery) Match(r *Record) bool {
+	if q == nil {
+		return true
+	}
+	if q.Streamer != "" && !r.Key.matches(q.Streamer) {
+		return false
+	}
+	if q.Platform != "" && q.Platform != r.Key.Platform {
+		return false
+	}
+	if q.Status != "" && q.Status != r.Status {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time().Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.Time().Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Select returns the records matching q, most recent first
+func (q *HistoryQuery) Select(records []*Record) []*Record {
+	var result []*Record
+	for _, r := range records {
+		if q.Match(r) {
+			result = append(result, r)
+		}
+	}
+	slices.SortStableFunc(result, func(a, b *Record) int {
+		return b.Time().Compare(a.Time())
+	})
+	if q != nil && q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result
+}
+
+// SetStore persists the recordings to store and restores the ones it holds.
+// Finished recordings go back to the history, so their lives are not recorded again.
+// Recordings the previous run left running are saved as failed with ErrInterrupted,
+// and their lives are recorded again if they are still on.
+func (ws *WatchLive) SetStore(store Store) error {
+	records, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	var interrupted []*Record
+	ws.mu.Lock()
+	ws.store = store
+	for _, r := range records {
+		info := r.Info()
+		switch info.Status {
+		case StatusCompleted, StatusFailed:
+			ws.latest[info.Key.StreamerID()] = info
+		default:
+			completedAt := r.UpdatedAt
+			info.Status = StatusFailed
+			info.CompletedAt = &completedAt
+			info.Error = ErrInterrupted
+			interrupted = append(interrupted, newRecord(info))
+		}
+		ws.history = append(ws.history, info)
+	}
+	ws.trimHistory()
+	ws.mu.Unlock()
+
+	for _, r := range interrupted {
+		logrus.Warnf("Recording of %s was interrupted by a restart", r.Key)
+		if err := store.Save(r); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Restored %d recordings from the store", len(records))
+	return nil
+}
+
+// persist saves info to the store if there is one
+func (ws *WatchLive) persist(info *RecordingInfo) {
+	ws.mu.RLock()
+	store := ws.store
+	var record *Record
+	if store != nil {
+		record = newRecord(info)
+	}
+	ws.mu.RUnlock()
+
+	if record != nil {
+		if err := store.Save(record); err != nil {
+			logrus.Warnf("Failed to save the recording of %s: %v", info.Key, err)
+		}
+	}
+}
+
+// QueryHistory returns the recordings matching q, most recent first, including those in progress.
+// With a store the whole stored history is searched, otherwise the recordings kept in memory.
+func (ws *WatchLive) QueryHistory(q *HistoryQuery) ([]*RecordingInfo, error) {
+	ws.mu.RLock()
+	store := ws.store
+	var records []*Record
+	if store == nil {
+		for _, info := range ws.history {
+			records = append(records, newRecord(info))
+		}
+		for _, info := range ws.recordings {
+			records = append(records, newRecord(info))
+		}
+	}
+	ws.mu.RUnlock()
+
+	if store != nil {
+		var err error
+		if records, err = store.Query(q); err != nil {
+			return nil, err
+		}
+	} else {
+		records = q.Select(records)
+	}
+
+	result := make([]*RecordingInfo, 0, len(records))
+	for _, r := range records {
+		result = append(result, r.Info())
+	}
+	return result, nil
+}
@@ -1,6 +1,8 @@
 package watch
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
@@ -8,19 +10,36 @@ import (
 
 	"math/rand"
 
+	"github.com/agilistikmal/live-recorder/pkg/downloader"
+	"github.com/agilistikmal/live-recorder/pkg/naming"
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
-	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultHistoryLimit is how many finished recordings a WatchLive keeps in its history
+const DefaultHistoryLimit = 1000
+
 type WatchLive struct {
-	liveRecorder recorder.Recorder
-	recordings   map[string]*RecordingInfo
-	mu           sync.RWMutex
-	wg           sync.WaitGroup
-	liveChan     chan *recorder.Live
-	statusChan   chan *StatusUpdate
-	outputDir    string
+	liveRecorder    recorder.Recorder
+	downloader      recorder.Downloader
+	sessionOptions  *session.Options
+	naming          *naming.Config
+	progressEvery   time.Duration
+	limits          *Limits
+	priority        Priority
+	retry           *RetryPolicy
+	store           Store
+	recordings      map[string]*RecordingInfo // Recordings in progress by recording key
+	latest          map[string]*RecordingInfo // Last finished recording of every streamer by platform/streamer
+	history         []*RecordingInfo          // Finished recordings, oldest first
+	historyLimit    int
+	platformResults []*recorder.PlatformResult
+	mu              sync.RWMutex
+	wg              sync.WaitGroup
+	liveChan        chan *recorder.Live
+	statusChan      chan *StatusUpdate
+	outputDir       string
 }
 
 func NewWatchLive(ls recorder.Recorder, outputDir string) *WatchLive {
@@ -30,8 +49,12 @@ func NewWatchLive(ls recorder.Recorder, outputDir string) *WatchLive {
 
 	return &WatchLive{
 		liveRecorder: ls,
+		downloader:   downloader.Default(),
+		naming:       naming.NewConfig(),
 		outputDir:    outputDir,
 		recordings:   make(map[string]*RecordingInfo),
+		latest:       make(map[string]*RecordingInfo),
+		historyLimit: DefaultHistoryLimit,
 		liveChan:     nil,
 		statusChan:   nil,
 	}
@@ -46,6 +69,68 @@ func (ws *WatchLive) SetLiveChannel(ch chan *recorder.Live) {
 	ws.liveChan = ch
 }
 
+// SetDownloader sets the backend used for new recordings.
+func (ws *WatchLive) SetDownloader(downloader recorder.Downloader) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.downloader = downloader
+}
+
+// SetSessionOptions sets how recordings resume after a dropped stream, nil means the defaults.
+func (ws *WatchLive) SetSessionOptions(opts *session.Options) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.sessionOptions = opts
+}
+
+// SetNaming sets the output path templates, relative to the output directory.
+func (ws *WatchLive) SetNaming(config *naming.Config) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.naming = config
+}
+
+// SetProgressInterval sets how often a recording in progress sends its progress to the status channel.
+// Zero means recorder.DefaultProgressInterval, a negative interval disables progress updates.
+func (ws *WatchLive) SetProgressInterval(interval time.Duration) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.progressEvery = interval
+}
+
+// SetLimits sets how many recordings run at the same time, nil means no limit.
+// Lives over a limit are queued until a recording finishes.
+func (ws *WatchLive) SetLimits(limits *Limits) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.limits = limits
+}
+
+// SetPriority sets how queued lives are ranked, nil records them in the order they were found.
+// See ByStreamers, ByFollowers, ByViews and Chain.
+func (ws *WatchLive) SetPriority(priority Priority) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.priority = priority
+}
+
+// SetRetryPolicy sets how a failed recording is started again, nil means the defaults.
+// Use a negative MaxRetries to fail a recording at the first error.
+func (ws *WatchLive) SetRetryPolicy(policy *RetryPolicy) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.retry = policy
+}
+
+// SetHistoryLimit sets how many finished recordings are kept in the history, the oldest are dropped first.
+// The last recording of every streamer is always kept, so a live is never recorded twice.
+func (ws *WatchLive) SetHistoryLimit(limit int) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.historyLimit = limit
+	ws.trimHistory()
+}
+
 // SetStatusChannel sets the channel for receiving status updates.
 // If channel is nil, no status updates will be sent.
 // Channel should be buffered to avoid blocking.
@@ -55,33 +140,68 @@ func (ws *WatchLive) SetStatusChannel(ch chan *StatusUpdate) {
 	ws.statusChan = ch
 }
 
-// GetStatus returns the recording info for a given streamer ID.
-// Returns nil if not found.
-func (ws *WatchLive) GetStatus(streamerID string) (*RecordingInfo, bool) {
+// GetStatus returns a recording by its key (platform/streamer/live), or the latest recording of a streamer
+// by platform/streamer or by the bare streamer name, which matches the streamer on any platform.
+// A recording in progress comes before a finished one. Returns nil if not found.
+func (ws *WatchLive) GetStatus(id string) (*RecordingInfo, bool) {
 	ws.mu.RLock()
 	defer ws.mu.RUnlock()
-	info, exists := ws.recordings[streamerID]
-	return info, exists
+	if info, exists := ws.recordings[id]; exists {
+		return info, true
+	}
+
+	if info := latestMatch(ws.recordings, id); info != nil {
+		return info, true
+	}
+	if info := latestMatch(ws.latest, id); info != nil {
+		return info, true
+	}
+	for i := len(ws.history) - 1; i >= 0; i-- {
+		if ws.history[i].Key.matches(id) {
+			return ws.history[i], true
+		}
+	}
+	return nil, false
 }
 
-// GetAllStatuses returns all recording statuses.
+// latestMatch returns the most recently started recording matching id
+func latestMatch(recordings map[string]*RecordingInfo, id string) *RecordingInfo {
+	var found *RecordingInfo
+	for _, info := range recordings {
+		if info.Key.matches(id) && (found == nil || info.StartedAt.After(found.StartedAt)) {
+			found = info
+		}
+	}
+	return found
+}
+
+// GetAllStatuses returns the recordings in progress and the last finished recording of every streamer,
+// by recording key.
 func (ws *WatchLive) GetAllStatuses() map[string]*RecordingInfo {
 	ws.mu.RLock()
 	defer ws.mu.RUnlock()
 
 	result := make(map[string]*RecordingInfo)
+	for _, v := range ws.latest {
+		result[v.Key.String()] = v
+	}
 	for k, v := range ws.recordings {
 		result[k] = v
 	}
 	return result
 }
 
-// GetStatusesByStatus returns all recordings with a specific status.
+// GetStatusesByStatus returns all recordings with a specific status, including those in the history.
 func (ws *WatchLive) GetStatusesByStatus(status RecordingStatus) []*RecordingInfo {
 	ws.mu.RLock()
 	defer ws.mu.RUnlock()
 
 	var result []*RecordingInfo
+	for _, info := range ws.history {
+		if info.Status == status {
+			result = append(result, info)
+		}
+	}
 	for _, info := range ws.recordings {
 		if info.Status == status {
 			result = append(result, info)
@@ -90,8 +210,100 @@ func (ws *WatchLive) GetStatusesByStatus(status RecordingStatus) []*RecordingInf
 	return result
 }
 
-// sendStatusUpdate sends a status update to the status channel (non-blocking)
-func (ws *WatchLive) sendStatusUpdate(streamerID string, status RecordingStatus, info *RecordingInfo) {
+// GetHistory returns the finished recordings, oldest first.
+func (ws *WatchLive) GetHistory() []*RecordingInfo {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return append([]*RecordingInfo(nil), ws.history...)
+}
+
+// finishRecording moves a recording from the running ones to the history, ws.mu must be held
+func (ws *WatchLive) finishRecording(info *RecordingInfo) {
+	delete(ws.recordings, info.Key.String())
+	ws.latest[info.Key.StreamerID()] = info
+	ws.history = append(ws.history, info)
+	ws.trimHistory()
+}
+
+// trimHistory drops the oldest finished recordings beyond the limit, ws.mu must be held
+func (ws *WatchLive) trimHistory() {
+	if ws.historyLimit > 0 && len(ws.history) > ws.historyLimit {
+		ws.history = append([]*RecordingInfo(nil), ws.history[len(ws.history)-ws.historyLimit:]...)
+	}
+}
+
+// GetPlatformResults returns the per-platform results of the last poll,
+// so an unavailable platform can be told apart from a platform with no lives.
+// Returns nil if the recorder does not report per-platform results.
+func (ws *WatchLive) GetPlatformResults() []*recorder.PlatformResult {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.platformResults
+}
+
+// getLives returns the current lives, keeping per-platform results when the recorder reports them.
+// polled reports whether a platform answered, so its missing lives can be told ended.
+func (ws *WatchLive) getLives(ctx context.Context) (lives []*recorder.Live, polled func(platform string) bool, err error) {
+	lister, ok := ws.liveRecorder.(recorder.PlatformLister)
+	if !ok {
+		lives, err = ws.liveRecorder.GetLivesContext(ctx)
+		return lives, func(string) bool { return true }, err
+	}
+
+	results, err := lister.GetPlatformLivesContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ws.mu.Lock()
+	ws.platformResults = results
+	ws.mu.Unlock()
+
+	lives = make([]*recorder.Live, 0)
+	answered := make(map[string]bool, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			logrus.Errorf("Platform %s is unavailable (took %v): %v", result.Platform, result.Latency, result.Err)
+			continue
+		}
+		logrus.Debugf("Platform %s returned %d lives in %v", result.Platform, len(result.Lives), result.Latency)
+		answered[result.Platform] = true
+		lives = append(lives, result.Lives...)
+	}
+	return lives, func(platform string) bool { return answered[platform] }, nil
+}
+
+// updateProgress keeps the latest progress of a recording and sends it to the status channel (non-blocking)
+func (ws *WatchLive) updateProgress(key string, progress recorder.Progress) {
+	ws.mu.Lock()
+	info := ws.recordings[key]
+	if info == nil || info.Status != StatusInProgress {
+		ws.mu.Unlock()
+		return
+	}
+	info.Progress = &progress
+	ch := ws.statusChan
+	ws.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- &StatusUpdate{
+			StreamerID: key,
+			Status:     StatusInProgress,
+			Info:       info,
+			Progress:   &progress,
+		}:
+		default:
+			// The next update replaces a dropped one, no need to warn
+			logrus.Debugf("Status channel is full, dropping progress update for %s", key)
+		}
+	}
+}
+
+// sendStatusUpdate saves the recording to the store and sends a status update to the status channel (non-blocking)
+func (ws *WatchLive) sendStatusUpdate(key string, status RecordingStatus, info *RecordingInfo) {
+	ws.persist(info)
+
 	ws.mu.RLock()
 	ch := ws.statusChan
 	ws.mu.RUnlock()
@@ -99,68 +311,110 @@ func (ws *WatchLive) sendStatusUpdate(streamerID string, status RecordingStatus,
 	if ch != nil {
 		select {
 		case ch <- &StatusUpdate{
-			StreamerID: streamerID,
+			StreamerID: key,
 			Status:     status,
 			Info:       info,
 		}:
 			// Successfully sent
 		default:
 			// Channel is full, log warning but don't block
-			logrus.Warnf("Status channel is full, dropping status update for %s", streamerID)
+			logrus.Warnf("Status channel is full, dropping status update for %s", key)
 		}
 	}
 }
 
 func (ws *WatchLive) StartWatchMode() {
+	ws.StartWatchModeContext(context.Background())
+}
+
+// StartWatchModeContext polls for new lives until ctx is cancelled.
+// Cancelling ctx also stops every recording started by this watcher.
+func (ws *WatchLive) StartWatchModeContext(ctx context.Context) {
 	logrus.Info("Watch mode started")
 
-	ws.CheckAndStartRecording()
+	ws.CheckAndStartRecordingContext(ctx)
 
 	tickerDuration := time.Duration(rand.Intn(15)+15) * time.Second
 	ticker := time.NewTicker(tickerDuration)
 	defer ticker.Stop()
-	for range ticker.C {
-		logrus.Debug("Checking for new live streams...")
-		ws.CheckAndStartRecording()
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("Watch mode stopped")
+			return
+		case <-ticker.C:
+			logrus.Debug("Checking for new live streams...")
+			ws.CheckAndStartRecordingContext(ctx)
+		}
+	}
+}
+
+// Wait blocks until all recordings started by this watcher have finished.
+func (ws *WatchLive) Wait() {
+	ws.wg.Wait()
+}
+
+// WaitContext is like Wait but gives up when ctx is done, returning ctx.Err().
+// Use it after cancelling the watch context to give recordings a deadline to finalize their files.
+func (ws *WatchLive) WaitContext(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ws.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 func (ws *WatchLive) CheckAndStartRecording() {
-	lives, err := ws.liveRecorder.GetLives()
+	ws.CheckAndStartRecordingContext(context.Background())
+}
+
+// CheckAndStartRecordingContext polls for lives, queues the new ones and starts as many
+// recordings as the limits allow, highest priority first.
+func (ws *WatchLive) CheckAndStartRecordingContext(ctx context.Context) {
+	lives, polled, err := ws.getLives(ctx)
 	if err != nil {
 		logrus.Errorf("Failed to get lives: %v", err)
 		return
 	}
 
+	var added []*RecordingInfo
+	listed := make(map[string]bool, len(lives))
 	for _, live := range lives {
-		streamerID := live.Streamer.Username
-
-		ws.mu.RLock()
-		_, exists := ws.recordings[streamerID]
-		ws.mu.RUnlock()
+		key := NewRecordingKey(live)
+		listed[key.String()] = true
 
-		if exists {
+		// A running recording already follows this live, and a finished one means it was recorded
+		// and the listing is lagging behind. A new live of the same streamer is recorded again.
+		ws.mu.Lock()
+		if info, exists := ws.recordings[key.String()]; exists {
+			if info.Status == StatusQueued {
+				info.Live = live // Fresh view count and streaming url for the ranking and the start
+			}
+			ws.mu.Unlock()
 			continue
 		}
-
-		streamingUrl, err := ws.liveRecorder.GetStreamingUrl(live)
-		if err != nil {
-			logrus.Errorf("Failed to get streaming url: %v", err)
-			return
+		if last := ws.latest[key.StreamerID()]; last != nil && session.SameLive(last.Live, live) {
+			ws.mu.Unlock()
+			continue
 		}
 
-		// Create recording info with InProgress status
 		recordingInfo := &RecordingInfo{
-			Live:      live,
-			Status:    StatusInProgress,
-			StartedAt: time.Now(),
+			Key:      key,
+			Live:     live,
+			Status:   StatusQueued,
+			QueuedAt: time.Now(),
 		}
-
-		// Store recording info
-		ws.mu.Lock()
-		ws.recordings[streamerID] = recordingInfo
+		ws.recordings[key.String()] = recordingInfo
 		liveCh := ws.liveChan
 		ws.mu.Unlock()
+		added = append(added, recordingInfo)
 
 		// Send live data to channel if available (non-blocking)
 		if liveCh != nil {
@@ -169,52 +423,210 @@ func (ws *WatchLive) CheckAndStartRecording() {
 				// Successfully sent to channel
 			default:
 				// Channel is full, log warning but don't block
-				logrus.Warnf("Live channel is full, dropping live data for %s", live.Streamer.Username)
+				logrus.Warnf("Live channel is full, dropping live data for %s", key)
 			}
 		}
+	}
+
+	ws.dropEnded(listed, polled)
+	ws.schedule(ctx)
+
+	for _, info := range added {
+		ws.mu.RLock()
+		queued := info.Status == StatusQueued
+		ws.mu.RUnlock()
+		if queued {
+			logrus.Infof("Queued the recording of %s, the concurrency limit is reached", info.Key)
+			ws.sendStatusUpdate(info.Key.String(), StatusQueued, info)
+		}
+	}
+}
+
+// dropEnded fails the queued recordings of lives that are no longer listed by a platform that answered
+func (ws *WatchLive) dropEnded(listed map[string]bool, polled func(platform string) bool) {
+	var ended []*RecordingInfo
+	ws.mu.Lock()
+	for key, info := range ws.recordings {
+		if info.Status != StatusQueued || listed[key] || !polled(info.Key.Platform) {
+			continue
+		}
+		now := time.Now()
+		info.Status = StatusFailed
+		info.CompletedAt = &now
+		info.Error = &recorder.NotLiveError{Platform: info.Key.Platform, Streamer: info.Key.Streamer}
+		ws.finishRecording(info)
+		ended = append(ended, info)
+	}
+	ws.mu.Unlock()
+
+	for _, info := range ended {
+		logrus.Infof("Live of %s ended before a recording slot was free", info.Key)
+		ws.sendStatusUpdate(info.Key.String(), StatusFailed, info)
+	}
+}
 
-		// Send status update for InProgress
-		ws.sendStatusUpdate(streamerID, StatusInProgress, recordingInfo)
+// schedule starts the queued recordings that fit the limits, highest ranked first,
+// then preempts a running recording for the highest ranked live still queued if allowed
+func (ws *WatchLive) schedule(ctx context.Context) {
+	for ctx.Err() == nil {
+		ws.mu.Lock()
+		info := ws.nextQueued()
+		if info == nil {
+			ws.preempt()
+			ws.mu.Unlock()
+			return
+		}
+		// Holding the slot while the streaming url is fetched
+		info.Status = StatusInProgress
+		info.StartedAt = time.Now()
+		ws.mu.Unlock()
+
+		ws.startRecording(ctx, info)
+	}
+}
 
-		ws.wg.Add(1)
-		go func(l recorder.Live, streamID string) {
-			defer ws.wg.Done()
+// startRecording records the live of info, whose slot is already taken.
+// A failed recording starts again while the live is on, as the retry policy allows.
+func (ws *WatchLive) startRecording(ctx context.Context, info *RecordingInfo) {
+	key := info.Key.String()
 
-			filename := fmt.Sprintf("%s/%s/%s.mp4", ws.outputDir, l.Platform, l.Streamer.Username)
-			downloadInfo := utils.DownloadHLS(streamingUrl, &filename)
+	recordCtx, cancel := context.WithCancel(ctx)
+	ws.mu.Lock()
+	info.cancel = cancel
+	live := *info.Live
+	dl := ws.downloader
+	sessionOptions := ws.sessionOptions
+	progressEvery := ws.progressEvery
+	retry := ws.retry.withDefaults()
+	outputPath := ws.naming.OutputPath(ws.outputDir, &live)
+	ws.mu.Unlock()
+
+	// Send status update for InProgress
+	ws.sendStatusUpdate(key, StatusInProgress, info)
+
+	ws.wg.Add(1)
+	go func() {
+		defer ws.wg.Done()
+		defer cancel()
+
+		if progressEvery >= 0 {
+			recordCtx = recorder.WithProgress(recordCtx, progressEvery, func(progress recorder.Progress) {
+				ws.updateProgress(key, progress)
+			})
+		}
 
-			// Update status based on result
+		var recordingSession *session.Session
+		for retries := 0; ; retries++ {
+			attempt := &Attempt{Number: retries + 1, StartedAt: time.Now()}
 			ws.mu.Lock()
-			recordingInfo := ws.recordings[streamID]
-			if recordingInfo == nil {
-				ws.mu.Unlock()
-				return
-			}
+			info.Attempts = append(info.Attempts, attempt)
+			ws.mu.Unlock()
 
-			if downloadInfo == nil {
-				// Recording failed
-				recordingInfo.Status = StatusFailed
-				recordingInfo.Error = fmt.Errorf("recording failed")
-				ws.mu.Unlock()
+			recordingSession = ws.record(recordCtx, dl, &live, outputPath, sessionOptions)
+			err := recordingSession.Result.Err
 
-				logrus.Errorf("Recording failed for %s", l.Streamer.Username)
-				ws.sendStatusUpdate(streamID, StatusFailed, recordingInfo)
+			ws.mu.Lock()
+			attempt.CompletedAt = time.Now()
+			attempt.Err = err
+			ws.mu.Unlock()
+			// Another try cannot help a downloader unable to read the stream
+			if err == nil || recordCtx.Err() != nil || retry.MaxRetries < 0 || retries >= retry.MaxRetries || errors.Is(err, recorder.ErrUnsupportedProtocol) {
+				break
+			}
+
+			delay := retry.backoff(retries + 1)
+			retryAt := time.Now().Add(delay)
+			logrus.Warnf("Recording failed for %s (attempt %d of %d), retrying in %v: %v", key, attempt.Number, retry.MaxRetries+1, delay, err)
+			ws.mu.Lock()
+			info.Status = StatusRetrying
+			info.RetryAt = &retryAt
+			info.Error = err
+			ws.mu.Unlock()
+			ws.sendStatusUpdate(key, StatusRetrying, info)
+
+			if !sleep(recordCtx, delay) {
+				break
+			}
+			current, on, err := ws.stillLive(recordCtx, &live)
+			if err != nil {
+				// Left to the next attempt, which fails on its own if the platform is still unreachable
+				logrus.Warnf("Failed to check whether %s is still live: %v", key, err)
+			} else if !on {
+				logrus.Infof("Live of %s has ended, not retrying", key)
+				break
 			} else {
-				// Recording completed
-				now := time.Now()
-				recordingInfo.Status = StatusCompleted
-				recordingInfo.CompletedAt = &now
-				recordingInfo.FilePath = filename
+				live = *current
+			}
 
-				// Extract file size if available
-				if size, ok := downloadInfo["size"].(int64); ok {
-					recordingInfo.FileSize = size
-				}
+			// A copy is published, live is overwritten by the next retry while others read info.Live
+			published := live
+			ws.mu.Lock()
+			info.Status = StatusInProgress
+			info.RetryAt = nil
+			info.Live = &published
+			ws.mu.Unlock()
+			ws.sendStatusUpdate(key, StatusInProgress, info)
+		}
+		downloadResult := recordingSession.Result
 
-				ws.mu.Unlock()
+		// Update status based on result and move the recording to the history
+		ws.mu.Lock()
+		recordingInfo := ws.recordings[key]
+		if recordingInfo != info {
+			ws.mu.Unlock()
+			return
+		}
+		ws.finishRecording(recordingInfo)
+		recordingInfo.cancel = nil
+		recordingInfo.RetryAt = nil
+
+		recordingInfo.Session = recordingSession
+		recordingInfo.Result = downloadResult
+		completedAt := downloadResult.CompletedAt
+		recordingInfo.CompletedAt = &completedAt
+
+		var requeued *RecordingInfo
+		if recordingInfo.Preempted && ctx.Err() == nil {
+			requeued = ws.requeue(recordingInfo)
+		}
 
-				ws.sendStatusUpdate(streamID, StatusCompleted, recordingInfo)
-			}
-		}(*live, streamerID)
+		if downloadResult.Err != nil {
+			// Recording failed
+			recordingInfo.Status = StatusFailed
+			recordingInfo.Error = downloadResult.Err
+			ws.mu.Unlock()
+
+			logrus.Errorf("Recording failed for %s: %v", key, downloadResult.Err)
+			ws.sendStatusUpdate(key, StatusFailed, recordingInfo)
+		} else {
+			// Recording completed
+			recordingInfo.Status = StatusCompleted
+			recordingInfo.Parts = recordingSession.Outputs
+			recordingInfo.FileSize = downloadResult.Size
+			recordingInfo.Error = nil
+			ws.mu.Unlock()
+
+			ws.sendStatusUpdate(key, StatusCompleted, recordingInfo)
+		}
+		if requeued != nil {
+			ws.sendStatusUpdate(key, StatusQueued, requeued)
+		}
+
+		// The freed slot goes to the next queued live
+		ws.schedule(ctx)
+	}()
+}
+
+// record runs one attempt at recording live with a fresh streaming url,
+// the session resumes while the same live is still on after a drop
+func (ws *WatchLive) record(ctx context.Context, dl recorder.Downloader, live *recorder.Live, outputPath string, opts *session.Options) *session.Session {
+	l := *live
+	streamingUrl, quality, err := recorder.ResolveStreamingUrl(ctx, ws.liveRecorder, &l)
+	if err != nil {
+		now := time.Now()
+		err = fmt.Errorf("failed to get streaming url: %w", err)
+		return &session.Session{Live: &l, Result: &recorder.DownloadResult{StartedAt: now, CompletedAt: now, ExitCode: -1, Err: err}}
 	}
+	l.StreamingUrl, l.Quality = streamingUrl, quality
+	return session.Record(ctx, ws.liveRecorder, dl, &l, outputPath, opts)
 }
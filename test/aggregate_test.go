@@ -0,0 +1,50 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/live"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeDownPlatform = "fake_down"
+
+func init() {
+	recorder.Register(fakeDownPlatform, func() recorder.Recorder {
+		return &fakeRecorder{err: errors.New("service unavailable")}
+	})
+}
+
+func TestLiveRecorder_PartialFailure(t *testing.T) {
+	liveRecorder := live.NewRecorder(&recorder.LiveQuery{
+		Platforms: []string{fakePlatform, fakeDownPlatform},
+	})
+
+	lives, err := liveRecorder.GetLives()
+	assert.NoError(t, err, "A single failing platform should not fail GetLives")
+	assert.Len(t, lives, 1)
+
+	results, err := liveRecorder.GetPlatformLivesContext(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	assert.Equal(t, fakePlatform, results[0].Platform)
+	assert.NoError(t, results[0].Err)
+	assert.Len(t, results[0].Lives, 1)
+
+	assert.Equal(t, fakeDownPlatform, results[1].Platform)
+	assert.Error(t, results[1].Err)
+	assert.Empty(t, results[1].Lives)
+}
+
+func TestLiveRecorder_AllPlatformsFailed(t *testing.T) {
+	liveRecorder := live.NewRecorder(&recorder.LiveQuery{
+		Platforms: []string{fakeDownPlatform},
+	})
+
+	_, err := liveRecorder.GetLives()
+	assert.Error(t, err, "GetLives should fail when every platform failed")
+}
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/downloader"
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/watch"
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDownloader records the requested downloads without touching the network
+type fakeDownloader struct {
+	mu        sync.Mutex
+	downloads []string
+	lives     []*recorder.Live // Lives passed along with the downloads
+}
+
+func (d *fakeDownloader) Name() string {
+	return "fake"
+}
+
+func (d *fakeDownloader) Capabilities() recorder.DownloaderCapabilities {
+	return recorder.DownloaderCapabilities{Protocols: []string{recorder.ProtocolHLS}, Container: "ts"}
+}
+
+func (d *fakeDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	d.mu.Lock()
+	d.downloads = append(d.downloads, url)
+	d.lives = append(d.lives, recorder.LiveFromContext(ctx))
+	d.mu.Unlock()
+
+	now := time.Now()
+	return &recorder.DownloadResult{
+		URL:         url,
+		OutputPath:  outputPath,
+		StartedAt:   now,
+		CompletedAt: now,
+		Duration:    time.Minute,
+		Size:        1024,
+	}
+}
+
+func TestDownloader_Backends(t *testing.T) {
+	backends := downloader.Backends()
+	assert.Contains(t, backends, downloader.BackendFFmpeg)
+	assert.Contains(t, backends, downloader.BackendNative)
+	assert.Contains(t, backends, downloader.BackendFLV)
+
+	for _, name := range backends {
+		d, err := downloader.New(name)
+		assert.NoError(t, err, "Failed to create downloader %s", name)
+		assert.Equal(t, name, d.Name())
+	}
+
+	native, _ := downloader.New(downloader.BackendNative)
+	assert.False(t, native.Capabilities().RequiresFFmpeg)
+	assert.False(t, native.Capabilities().Supports(recorder.ProtocolFLV))
+
+	_, err := downloader.New("unknown")
+	assert.Error(t, err, "Unknown downloader should fail")
+}
+
+func TestDownloader_FLV(t *testing.T) {
+	// FLV header, PreviousTagSize0 and two empty tags at 0ms and 2500ms
+	stream := append([]byte("FLV\x01\x05\x00\x00\x00\x09"), 0, 0, 0, 0)
+	for _, ts := range []uint32{0, 2500} {
+		tag := make([]byte, 11+4)
+		tag[0] = 9
+		tag[4], tag[5], tag[6], tag[7] = byte(ts>>16), byte(ts>>8), byte(ts), byte(ts>>24)
+		binary.BigEndian.PutUint32(tag[11:], 11)
+		stream = append(stream, tag...)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(stream)
+	}))
+	defer server.Close()
+
+	d := downloader.NewFLV()
+	result := d.Download(context.Background(), server.URL+"/live.flv", filepath.Join(t.TempDir(), "live.mp4"))
+	assert.NoError(t, result.Err, "Failed to download")
+	assert.Equal(t, ".flv", filepath.Ext(result.OutputPath))
+	assert.Equal(t, int64(len(stream)), result.Size)
+	assert.Equal(t, 2500*time.Millisecond, result.Duration)
+
+	data, err := os.ReadFile(result.OutputPath)
+	assert.NoError(t, err, "Failed to read output")
+	assert.Equal(t, stream, data)
+}
+
+func TestWatchLive_Downloader(t *testing.T) {
+	fake := &fakeDownloader{}
+	statusChan := make(chan *watch.StatusUpdate, 10)
+
+	watchService := watch.NewWatchLive(&fakeRecorder{lives: fakePlatformRecorder.lives, ended: true}, t.TempDir())
+	watchService.SetDownloader(fake)
+	watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond})
+	watchService.SetStatusChannel(statusChan)
+	watchService.CheckAndStartRecording()
+	watchService.Wait()
+
+	assert.Equal(t, []string{"https://fake.example/live/1.m3u8"}, fake.downloads)
+
+	info, exists := watchService.GetStatus("fake_user")
+	assert.True(t, exists)
+	assert.Equal(t, watch.StatusCompleted, info.Status)
+	assert.Equal(t, int64(1024), info.FileSize)
+	assert.Equal(t, time.Minute, info.Duration())
+}
+
+func TestDownloader_Format(t *testing.T) {
+	assert.Equal(t, recorder.FormatTS, utils.FormatFromExt("./tmp/idn/user.ts"))
+	assert.Equal(t, recorder.FormatMKV, utils.FormatFromExt("./tmp/idn/user.MKV"))
+	assert.Equal(t, recorder.FormatMP4, utils.FormatFromExt("./tmp/idn/user.mp4"))
+	assert.Equal(t, ".mp4", utils.FormatExt(recorder.FormatFMP4))
+
+	d := downloader.NewFFmpeg()
+	formatSetter, ok := d.(recorder.FormatSetter)
+	assert.True(t, ok, "ffmpeg downloader should support output formats")
+	assert.NoError(t, formatSetter.SetFormat(recorder.FormatMKV, false))
+	assert.Equal(t, "mkv", d.Capabilities().Container)
+	assert.NoError(t, formatSetter.SetFormat(recorder.FormatMKV, true))
+	assert.Equal(t, "mp4", d.Capabilities().Container)
+	assert.Error(t, formatSetter.SetFormat("avi", false), "Unknown format should fail")
+
+	native := downloader.NewNative().(recorder.FormatSetter)
+	assert.Error(t, native.SetFormat(recorder.FormatMKV, false), "Native downloader only writes TS")
+}
+
+func TestDownloader_Metadata(t *testing.T) {
+	startedAt := time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC)
+	live := &recorder.Live{
+		Title:       "Friday night",
+		Platform:    recorder.PlatformShowroom,
+		PlatformUrl: "https://showroom-live.com/r/user",
+		StartedAt:   &startedAt,
+		Streamer:    &recorder.LiveStreamer{Username: "user", Name: "User Name", ImageUrl: "https://example.com/avatar.jpg"},
+	}
+
+	metadata := utils.LiveMetadata(live)
+	assert.Equal(t, "Friday night", metadata.Tags["title"])
+	assert.Equal(t, "User Name", metadata.Tags["artist"])
+	assert.Equal(t, "user", metadata.Tags["album_artist"])
+	assert.Equal(t, recorder.PlatformShowroom, metadata.Tags["network"])
+	assert.Equal(t, "https://showroom-live.com/r/user", metadata.Tags["comment"])
+	assert.Equal(t, "2024-05-01T19:30:00Z", metadata.Tags["creation_time"])
+	assert.Equal(t, "https://example.com/avatar.jpg", metadata.CoverUrl, "The streamer image is the cover when the live has none")
+	assert.Nil(t, utils.LiveMetadata(nil))
+
+	// The session passes the live to the downloader, which writes it into the file
+	d := &fakeDownloader{}
+	rec := &fakeRecorder{lives: fakePlatformRecorder.lives, ended: true}
+	session.Record(context.Background(), rec, d, fakePlatformRecorder.lives[0], filepath.Join(t.TempDir(), "fake_user.ts"), &session.Options{ResumeDelay: time.Millisecond})
+	if assert.NotEmpty(t, d.lives) {
+		assert.Same(t, fakePlatformRecorder.lives[0], d.lives[0])
+	}
+}
+
+func TestDownloader_FLVSignature(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:1\n"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	result := downloader.NewFLV().Download(context.Background(), server.URL+"/live.flv", filepath.Join(dir, "live.flv"))
+	assert.ErrorIs(t, result.Err, recorder.ErrUnsupportedProtocol)
+	files, _ := os.ReadDir(dir)
+	assert.Empty(t, files, "Nothing should be saved from a stream that is not FLV")
+}
+
+func TestDownloader_Protocol(t *testing.T) {
+	assert.Equal(t, recorder.ProtocolHLS, recorder.StreamProtocol("https://example.com/live/index.m3u8?token=1"))
+	assert.Equal(t, recorder.ProtocolFLV, recorder.StreamProtocol("https://example.com/live/stream.flv?expire=1"))
+
+	flv := downloader.NewFLV()
+	assert.ErrorIs(t, recorder.CheckProtocol(flv, "https://example.com/index.m3u8"), recorder.ErrUnsupportedProtocol)
+	assert.NoError(t, recorder.CheckProtocol(flv, "https://example.com/stream.flv"))
+
+	live := *fakePlatformRecorder.lives[0]
+	live.StreamingUrl = "https://example.com/index.m3u8"
+	s := session.Record(context.Background(), &fakeRecorder{lives: fakePlatformRecorder.lives}, flv, &live, filepath.Join(t.TempDir(), "live.mp4"), nil)
+	assert.ErrorIs(t, s.Result.Err, recorder.ErrUnsupportedProtocol, "An HLS stream should not be given to the FLV downloader")
+	assert.Empty(t, s.Parts)
+}
@@ -0,0 +1,233 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/hls"
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHLSTestServer serves a master playlist, a media playlist that advances on every reload
+// and segments whose body is their own name. Earlier segments respond slower than later ones.
+func newHLSTestServer(playlists []string) *httptest.Server {
+	var mu sync.Mutex
+	reloads := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=640x360\nlow/index.m3u8\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS=\"avc1.4d401f,mp4a.40.2\"\nhigh/index.m3u8\n")
+	})
+	mux.HandleFunc("/high/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		playlist := playlists[min(reloads, len(playlists)-1)]
+		reloads++
+		mu.Unlock()
+		fmt.Fprint(w, playlist)
+	})
+	mux.HandleFunc("/high/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/high/"), ".ts")
+		if name == "seg0" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		fmt.Fprint(w, name+";")
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestHLS_ParsePlaylist(t *testing.T) {
+	master, media, err := hls.ParsePlaylist([]byte("#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=500000\nlow.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=900000,CODECS=\"avc1,mp4a\"\nhttps://cdn.example/high.m3u8\n"),
+		"https://example.com/live/master.m3u8")
+	assert.NoError(t, err)
+	assert.Nil(t, media)
+	assert.Len(t, master.Variants, 2)
+	assert.Equal(t, "https://example.com/live/low.m3u8", master.Variants[0].URI)
+	assert.Equal(t, "avc1,mp4a", master.Variants[1].Codecs)
+
+	best, ok := master.BestVariant()
+	assert.True(t, ok)
+	assert.Equal(t, "https://cdn.example/high.m3u8", best.URI)
+
+	master, media, err = hls.ParsePlaylist([]byte("#EXTM3U\n"+
+		"#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:10\n#EXT-X-DISCONTINUITY-SEQUENCE:3\n"+
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x000102030405060708090a0b0c0d0e0f\n"+
+		"#EXTINF:2.000,\na.ts\n#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:1.5,\nb.ts\n#EXT-X-ENDLIST\n"),
+		"https://example.com/live/index.m3u8")
+	assert.NoError(t, err)
+	assert.Nil(t, master)
+	assert.True(t, media.EndList)
+	assert.Equal(t, 2*time.Second, media.TargetDuration)
+	assert.Len(t, media.Segments, 2)
+	assert.Equal(t, int64(10), media.Segments[0].Sequence)
+	assert.Equal(t, int64(3), media.Segments[0].DiscontinuitySequence)
+	assert.Equal(t, "https://example.com/live/key.bin", media.Segments[0].Key.URI)
+	assert.Len(t, media.Segments[0].Key.IV, 16)
+	assert.Equal(t, int64(11), media.Segments[1].Sequence)
+	assert.Equal(t, int64(4), media.Segments[1].DiscontinuitySequence)
+	assert.True(t, media.Segments[1].Discontinuity)
+	assert.Equal(t, 1500*time.Millisecond, media.Segments[1].Duration)
+	assert.Equal(t, "https://example.com/live/init.mp4", media.Segments[1].Map.URI)
+
+	_, _, err = hls.ParsePlaylist([]byte("<html>"), "https://example.com")
+	assert.ErrorIs(t, err, hls.ErrNotPlaylist)
+}
+
+func TestHLS_DownloadLive(t *testing.T) {
+	server := newHLSTestServer([]string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXTINF:1,\nseg0.ts\n#EXTINF:1,\nseg1.ts\n#EXTINF:1,\nseg2.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:1\n" +
+			"#EXTINF:1,\nseg1.ts\n#EXTINF:1,\nseg2.ts\n#EXTINF:1,\nseg3.ts\n",
+		// seg3 announced again under a new sequence number, then a discontinuity
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:2\n" +
+			"#EXTINF:1,\nseg2.ts\n#EXTINF:1,\nseg3.ts\n#EXTINF:1,\nseg3.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:1,\nseg5.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:4\n#EXT-X-DISCONTINUITY-SEQUENCE:0\n" +
+			"#EXTINF:1,\nseg3.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:1,\nseg5.ts\n#EXTINF:1,\nseg6.ts\n#EXT-X-ENDLIST\n",
+	})
+	defer server.Close()
+
+	var output bytes.Buffer
+	stats, err := hls.Download(context.Background(), server.URL+"/master.m3u8", &output, &hls.Options{
+		Concurrency:  4,
+		PollInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "seg0;seg1;seg2;seg3;seg5;seg6;", output.String())
+	assert.Equal(t, 6, stats.Segments)
+	assert.Equal(t, 6*time.Second, stats.Duration)
+	assert.Equal(t, int64(output.Len()), stats.Bytes)
+	assert.Equal(t, 1, stats.Duplicates)
+	assert.Equal(t, 1, stats.Discontinuities)
+}
+
+func TestHLS_DownloadStall(t *testing.T) {
+	server := newHLSTestServer([]string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1,\nseg1.ts\n",
+	})
+	defer server.Close()
+
+	var output bytes.Buffer
+	stats, err := hls.Download(context.Background(), server.URL+"/high/index.m3u8", &output, &hls.Options{
+		PollInterval: 10 * time.Millisecond,
+		StallTimeout: 100 * time.Millisecond,
+	})
+	assert.NoError(t, err, "A stalled live playlist is the end of the stream")
+	assert.Equal(t, 1, stats.Segments)
+	assert.Equal(t, "seg1;", output.String())
+}
+
+func TestHLS_DownloadCancel(t *testing.T) {
+	server := newHLSTestServer([]string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1,\nseg1.ts\n",
+	})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var output bytes.Buffer
+	stats, err := hls.Download(ctx, server.URL+"/high/index.m3u8", &output, &hls.Options{
+		PollInterval: 10 * time.Millisecond,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, stats.Segments, "Segments written before cancelling are kept")
+}
+
+func TestHLS_DownloadLimit(t *testing.T) {
+	server := newHLSTestServer([]string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXTINF:1,\nseg0.ts\n#EXTINF:1,\nseg1.ts\n#EXTINF:1,\nseg2.ts\n#EXTINF:1,\nseg3.ts\n",
+	})
+	defer server.Close()
+
+	var output bytes.Buffer
+	stats, err := hls.Download(context.Background(), server.URL+"/master.m3u8", &output, &hls.Options{
+		PollInterval: 10 * time.Millisecond,
+		MaxDuration:  2 * time.Second,
+	})
+	assert.NoError(t, err, "Reaching the limit is a normal end")
+	assert.True(t, stats.Limited)
+	assert.Equal(t, "seg0;seg1;", output.String())
+}
+
+func TestHLS_DownloadProgress(t *testing.T) {
+	server := newHLSTestServer([]string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXTINF:1,\nseg0.ts\n#EXTINF:1,\nseg1.ts\n#EXTINF:1,\nseg2.ts\n#EXT-X-ENDLIST\n",
+	})
+	defer server.Close()
+
+	var progress []recorder.Progress
+	ctx := recorder.WithProgress(context.Background(), time.Nanosecond, func(p recorder.Progress) {
+		progress = append(progress, p)
+	})
+	outputPath := filepath.Join(t.TempDir(), "live.ts")
+	result := utils.DownloadHLSNativeWithOptions(ctx, server.URL+"/master.m3u8", &outputPath, nil)
+	assert.NoError(t, result.Err)
+
+	if assert.Len(t, progress, 3, "Every segment should report progress") {
+		last := progress[2]
+		assert.Equal(t, outputPath, last.OutputPath)
+		assert.Equal(t, 3*time.Second, last.Elapsed)
+		assert.Equal(t, int64(len("seg0;seg1;seg2;")), last.Size)
+		assert.Equal(t, 3, last.Segments)
+		assert.Equal(t, float64(len("seg2;")*8), last.Bitrate, "Bitrate covers the media since the previous report")
+		assert.Greater(t, last.Speed, 0.0)
+	}
+}
+
+func TestHLS_DownloadDiscontinuityLeavesWindow(t *testing.T) {
+	// No EXT-X-DISCONTINUITY-SEQUENCE, so the discontinuity number of seg2 goes back to 0 once the tag scrolls out
+	server := newHLSTestServer([]string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXTINF:1,\nseg0.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:1,\nseg1.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:1\n" +
+			"#EXTINF:1,\nseg1.ts\n#EXTINF:1,\nseg2.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:2\n" +
+			"#EXTINF:1,\nseg2.ts\n#EXTINF:1,\nseg3.ts\n#EXT-X-ENDLIST\n",
+	})
+	defer server.Close()
+
+	var output bytes.Buffer
+	stats, err := hls.Download(context.Background(), server.URL+"/master.m3u8", &output, &hls.Options{
+		PollInterval: 10 * time.Millisecond,
+		StallTimeout: time.Second,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "seg0;seg1;seg2;seg3;", output.String(), "Segments after the discontinuity left the window are new")
+	assert.Equal(t, 1, stats.Discontinuities)
+}
+
+func TestHLS_DownloadReset(t *testing.T) {
+	server := newHLSTestServer([]string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXTINF:1,\nseg0.ts\n#EXTINF:1,\nseg1.ts\n",
+		// The stream restarted, its media sequence starts over under a new discontinuity sequence
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+			"#EXTINF:1,\nnew0.ts\n#EXTINF:1,\nnew1.ts\n#EXT-X-ENDLIST\n",
+	})
+	defer server.Close()
+
+	var output bytes.Buffer
+	stats, err := hls.Download(context.Background(), server.URL+"/master.m3u8", &output, &hls.Options{
+		PollInterval: 10 * time.Millisecond,
+		StallTimeout: time.Second,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "seg0;seg1;new0;new1;", output.String())
+	assert.Equal(t, 1, stats.Discontinuities)
+}
@@ -0,0 +1,30 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder/idn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDNLiveService_GetLive(t *testing.T) {
+	idnRecorder := idn.NewRecorder()
+	idnLives, err := idnRecorder.GetLives()
+	assert.NoError(t, err, "Failed to get idn lives")
+	if len(idnLives) < 1 {
+		t.Skip("No idn lives found")
+	}
+
+	live, err := idnRecorder.GetLive(idnLives[0].PlatformUrl)
+	assert.NoError(t, err, "Failed to get idn live by url")
+	assert.NotNil(t, live, "Live is nil")
+	assert.Equal(t, idnLives[0].ID, live.ID)
+	assert.NotEmpty(t, live.StreamingUrl, "Live streaming url is empty")
+
+	live, err = idnRecorder.GetLive(idnLives[0].Streamer.Username)
+	assert.NoError(t, err, "Failed to get idn live by username")
+	assert.NotNil(t, live, "Live is nil")
+
+	t.Logf("Live Title: %s", live.Title)
+	t.Logf("Live Streaming Url: %s", live.StreamingUrl)
+}
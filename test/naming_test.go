@@ -0,0 +1,92 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/naming"
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNaming_Render(t *testing.T) {
+	live := &recorder.Live{
+		ID:       "abc",
+		Title:    "Live: today / with \"friends\"?",
+		Platform: recorder.PlatformIDN,
+		Streamer: &recorder.LiveStreamer{Username: "jkt48_user", Name: "JKT48 User"},
+	}
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	startedAt := time.Date(2024, 5, 1, 20, 30, 15, 0, time.UTC).In(jakarta)
+
+	template, err := naming.Parse("{platform}/{name}/{date}_{time}_{title}_{part}.mp4")
+	assert.NoError(t, err, "Failed to parse template")
+	path := template.Render(naming.Fields{Live: live, Part: 2, Time: startedAt})
+	assert.Equal(t, filepath.Join("idn", "JKT48 User", "2024-05-02_03-30-15_Live_ today _ with _friends___002.mp4"), path)
+
+	template = naming.MustParse("{username}_{{literal}}.ts")
+	assert.Equal(t, "unknown_{literal}.ts", template.Render(naming.Fields{Live: &recorder.Live{}}))
+
+	_, err = naming.Parse("{platform}/{unknown}.mp4")
+	assert.Error(t, err, "Unknown field should fail")
+	_, err = naming.Parse("{platform.mp4")
+	assert.Error(t, err, "Unclosed field should fail")
+}
+
+func TestNaming_Rules(t *testing.T) {
+	config := naming.NewConfig()
+	assert.NoError(t, config.ParseRule("idn={platform}/{name}.mkv"))
+	assert.NoError(t, config.ParseRule("idn/special_user=special/{id}.ts"))
+	assert.Error(t, config.ParseRule("no template"))
+
+	live := &recorder.Live{Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "Special_User"}}
+	assert.Equal(t, "special/{id}.ts", config.Template(live).String(), "Streamer rule should win")
+
+	live.Streamer.Username = "other"
+	assert.Equal(t, "{platform}/{name}.mkv", config.Template(live).String(), "Platform rule should apply")
+
+	live.Platform = recorder.PlatformShowroom
+	assert.Equal(t, naming.DefaultTemplate, config.Template(live).String())
+	assert.Equal(t, filepath.Join("out{{dir}}", naming.DefaultTemplate), config.OutputPath("out{dir}", live))
+}
+
+func TestNaming_UniquePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "user.mp4")
+	assert.Equal(t, path, utils.UniquePath(path))
+
+	assert.NoError(t, os.WriteFile(path, nil, 0644))
+	assert.Equal(t, filepath.Join(dir, "user_1.mp4"), utils.UniquePath(path))
+}
+
+func TestNaming_ReservePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "user.mp4")
+
+	// Recordings finalizing together each get their own name
+	names := make(chan string, 10)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name, err := utils.ReservePath(path)
+			assert.NoError(t, err)
+			names <- name
+		}()
+	}
+	wg.Wait()
+	close(names)
+
+	seen := make(map[string]bool)
+	for name := range names {
+		assert.False(t, seen[name], "Name %s was reserved twice", name)
+		seen[name] = true
+	}
+	assert.True(t, seen[path])
+	assert.True(t, seen[filepath.Join(dir, "user_9.mp4")])
+}
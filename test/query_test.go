@@ -0,0 +1,104 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/query"
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueryTestLive() *recorder.Live {
+	startedAt := time.Now().Add(-45 * time.Minute)
+	return &recorder.Live{
+		ID:        "123",
+		Title:     "Pagi Ceria JKT48",
+		Platform:  recorder.PlatformShowroom,
+		ViewCount: 1500,
+		StartedAt: &startedAt,
+		Streamer: &recorder.LiveStreamer{
+			Username:      "48_Freya",
+			Name:          "Freya",
+			FollowerCount: 20000,
+		},
+	}
+}
+
+func TestQuery_Match(t *testing.T) {
+	live := newQueryTestLive()
+	cases := map[string]bool{
+		`platform = showroom`:                                         true,
+		`platform == IDN`:                                             false,
+		`platform != idn`:                                             true,
+		`streamer_username = 48_*`:                                    true,
+		`username = *_jkt48`:                                          false,
+		`title ~ "jkt(48)?$"`:                                         true,
+		`title !~ pagi`:                                               false,
+		`view_count > 1000 AND follower_count >= 20000`:               true,
+		`view_count < 1000 OR followers < 100`:                        false,
+		`NOT view_count < 1000`:                                       true,
+		`!(platform = idn || platform = tiktok)`:                      true,
+		`uptime > 30m AND uptime < 1h`:                                true,
+		`uptime < 1d AND uptime > 2h`:                                 false,
+		`platform = idn OR platform = showroom AND title = "*jkt48*"`: true,
+		`(platform = idn OR platform = showroom) AND name = "Freya"`:  true,
+	}
+
+	for expression, expected := range cases {
+		expr, err := query.Parse(expression)
+		if !assert.NoError(t, err, "Failed to parse %s", expression) {
+			continue
+		}
+		assert.Equal(t, expected, expr.Match(live), "Wrong match for %s (%s)", expression, expr)
+	}
+}
+
+func TestQuery_Precedence(t *testing.T) {
+	expr := query.MustParse(`platform = a OR platform = b AND NOT title = c`)
+	assert.Equal(t, `(platform = "a" OR (platform = "b" AND NOT title = "c"))`, expr.String())
+}
+
+func TestQuery_SyntaxErrors(t *testing.T) {
+	cases := map[string]int{
+		``:                        1,
+		`title`:                   6,
+		`title =`:                 8,
+		`unknown = 1`:             1,
+		`view_count > many`:       14,
+		`view_count ~ 1`:          12,
+		`title < abc`:             7,
+		`uptime > soon`:           10,
+		`(platform = idn`:         16,
+		`platform = idn extra`:    16,
+		`title = "unterminated`:   9,
+		`title ~ "("`:             9,
+		`platform = idn AND OR x`: 20,
+	}
+
+	for expression, pos := range cases {
+		_, err := query.Parse(expression)
+		var syntaxErr *query.SyntaxError
+		if assert.ErrorAs(t, err, &syntaxErr, "Expected syntax error for %q", expression) {
+			assert.Equal(t, pos, syntaxErr.Pos, "Wrong error position for %q: %v", expression, err)
+		}
+	}
+}
+
+func TestParseLiveQuery(t *testing.T) {
+	liveQuery := &recorder.LiveQuery{}
+	err := utils.ParseLiveQuery("streamer_username:*_JKT48", liveQuery)
+	assert.NoError(t, err)
+	assert.Equal(t, "*_JKT48", liveQuery.StreamerUsernameLike)
+	assert.Nil(t, liveQuery.Filter)
+
+	liveQuery = &recorder.LiveQuery{}
+	err = utils.ParseLiveQuery("streamer_username = 48_* AND view_count > 100", liveQuery)
+	assert.NoError(t, err)
+	assert.NotNil(t, liveQuery.Filter)
+	assert.True(t, liveQuery.Filter.Match(newQueryTestLive()))
+
+	err = utils.ParseLiveQuery("view_count >", &recorder.LiveQuery{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeOrphan writes a temporary part as a killed recording would leave it
+func writeOrphan(t *testing.T, path string, data string, modTime time.Time) {
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestRecover_OrphanedParts(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "showroom")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+
+	// Two parts of one session a few seconds apart, and a later session of the same streamer
+	writeOrphan(t, filepath.Join(dir, "user_1000.tmp.ts"), "a;", time.Unix(1060, 0))
+	writeOrphan(t, filepath.Join(dir, "user_1070.tmp.ts"), "b;", time.Unix(1200, 0))
+	writeOrphan(t, filepath.Join(dir, "user_9000.tmp.ts"), "c;", time.Unix(9100, 0))
+	writeOrphan(t, filepath.Join(dir, "user_1000.list"), "file 'user_1000.tmp.ts'\n", time.Unix(1200, 0))
+	// Named after an output path template
+	writeOrphan(t, filepath.Join(dir, "2024-05-01_title.1714570000.tmp.ts"), "e;", time.Unix(1714570100, 0))
+	// Still being written by a running recording
+	writeOrphan(t, filepath.Join(dir, "other_2000.tmp.ts"), "d;", time.Now())
+
+	report, err := session.Recover(context.Background(), dir, nil)
+	assert.NoError(t, err, "Failed to recover")
+	assert.Len(t, report.Sessions, 3)
+	assert.Empty(t, report.Failed())
+	assert.Equal(t, []string{filepath.Join(dir, "user_1000.list")}, report.Removed)
+
+	data, err := os.ReadFile(filepath.Join(dir, "user_1000.ts"))
+	assert.NoError(t, err, "First session should be recovered")
+	assert.Equal(t, "a;b;", string(data))
+
+	data, err = os.ReadFile(filepath.Join(dir, "user_9000.ts"))
+	assert.NoError(t, err, "Second session should be recovered")
+	assert.Equal(t, "c;", string(data))
+
+	data, err = os.ReadFile(filepath.Join(dir, "2024-05-01_title.ts"))
+	assert.NoError(t, err, "Templated part should be recovered under its output name")
+	assert.Equal(t, "e;", string(data))
+
+	remaining, _ := filepath.Glob(filepath.Join(dir, "*.tmp.ts"))
+	assert.Equal(t, []string{filepath.Join(dir, "other_2000.tmp.ts")}, remaining, "Recent parts must be left alone")
+}
+
+func TestRecover_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeOrphan(t, filepath.Join(dir, "user_1000.tmp.ts"), "a;", time.Unix(1060, 0))
+
+	report, err := session.Recover(context.Background(), dir, &session.RecoverOptions{DryRun: true})
+	assert.NoError(t, err, "Failed to recover")
+	assert.Len(t, report.Sessions, 1)
+
+	_, err = os.Stat(filepath.Join(dir, "user_1000.tmp.ts"))
+	assert.NoError(t, err, "Dry run must not touch the parts")
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/live"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakePlatform = "fake"
+
+// fakeRecorder is an in-memory platform used to test code built on top of recorder.Recorder
+type fakeRecorder struct {
+	lives    []*recorder.Live
+	err      error
+	ended    bool // Report every live as over when looked up by URL
+	recorded []string
+}
+
+func (f *fakeRecorder) GetLives() ([]*recorder.Live, error) {
+	return f.GetLivesContext(context.Background())
+}
+
+func (f *fakeRecorder) GetLivesContext(ctx context.Context) ([]*recorder.Live, error) {
+	return f.lives, f.err
+}
+
+func (f *fakeRecorder) GetLive(url string) (*recorder.Live, error) {
+	return f.GetLiveContext(context.Background(), url)
+}
+
+func (f *fakeRecorder) GetLiveContext(ctx context.Context, url string) (*recorder.Live, error) {
+	if f.ended {
+		return nil, &recorder.NotLiveError{Platform: fakePlatform, Streamer: url}
+	}
+	for _, l := range f.lives {
+		if l.PlatformUrl == url {
+			return l, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeRecorder) GetStreamingUrl(live *recorder.Live) (string, error) {
+	return f.GetStreamingUrlContext(context.Background(), live)
+}
+
+func (f *fakeRecorder) GetStreamingUrlContext(ctx context.Context, live *recorder.Live) (string, error) {
+	return live.StreamingUrl, nil
+}
+
+func (f *fakeRecorder) Record(live *recorder.Live, outputPath string) error {
+	return f.RecordContext(context.Background(), live, outputPath)
+}
+
+func (f *fakeRecorder) RecordContext(ctx context.Context, live *recorder.Live, outputPath string) error {
+	f.recorded = append(f.recorded, outputPath)
+	return nil
+}
+
+var fakePlatformRecorder = &fakeRecorder{
+	lives: []*recorder.Live{
+		{
+			ID:           "fake-1",
+			Title:        "Fake live",
+			Platform:     fakePlatform,
+			PlatformUrl:  "https://fake.example/live/1",
+			StreamingUrl: "https://fake.example/live/1.m3u8",
+			Streamer:     &recorder.LiveStreamer{Username: "fake_user"},
+		},
+	},
+}
+
+func init() {
+	recorder.Register(fakePlatform, func() recorder.Recorder { return fakePlatformRecorder })
+}
+
+func TestRegistry_Platforms(t *testing.T) {
+	platforms := recorder.Platforms()
+	assert.Contains(t, platforms, fakePlatform)
+
+	_, err := recorder.New("unknown")
+	assert.Error(t, err, "Unknown platform should fail")
+}
+
+func TestLiveRecorder_RegisteredPlatform(t *testing.T) {
+	liveRecorder := live.NewRecorder(&recorder.LiveQuery{
+		Platforms: []string{fakePlatform},
+	})
+
+	lives, err := liveRecorder.GetLives()
+	assert.NoError(t, err, "Failed to get lives")
+	assert.Len(t, lives, 1)
+
+	err = liveRecorder.Record(lives[0], "fake.mp4")
+	assert.NoError(t, err, "Failed to record")
+	assert.Contains(t, fakePlatformRecorder.recorded, "fake.mp4")
+}
+
+// refreshingRecorder returns a new streaming url on every lookup
+type refreshingRecorder struct {
+	fakeRecorder
+}
+
+func (f *refreshingRecorder) GetStreamingUrlContext(ctx context.Context, live *recorder.Live) (string, error) {
+	return live.StreamingUrl + "?refreshed", nil
+}
+
+func TestRegistry_ResolveStreamingUrl(t *testing.T) {
+	live := &recorder.Live{StreamingUrl: "https://example.com/live.m3u8", Quality: "high"}
+
+	streamingUrl, quality, err := recorder.ResolveStreamingUrl(context.Background(), &fakeRecorder{}, live)
+	assert.NoError(t, err)
+	assert.Equal(t, live.StreamingUrl, streamingUrl)
+	assert.Equal(t, "high", quality, "The same url keeps its quality")
+
+	streamingUrl, quality, err = recorder.ResolveStreamingUrl(context.Background(), &refreshingRecorder{}, live)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/live.m3u8?refreshed", streamingUrl)
+	assert.Empty(t, quality, "The quality of another url is unknown")
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// droppingRecorder reports its live as still on for a number of checks, then as ended
+type droppingRecorder struct {
+	*fakeRecorder
+	stillLive int
+}
+
+func (r *droppingRecorder) GetLiveContext(ctx context.Context, url string) (*recorder.Live, error) {
+	if r.stillLive == 0 {
+		return nil, &recorder.NotLiveError{Platform: fakePlatform, Streamer: url}
+	}
+	r.stillLive--
+	return r.fakeRecorder.GetLiveContext(ctx, url)
+}
+
+// partDownloader writes a small MPEG-TS like part for every download, as if the stream dropped each time
+type partDownloader struct {
+	fakeDownloader
+	parts int
+}
+
+func (d *partDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	d.parts++
+	result := d.fakeDownloader.Download(ctx, url, outputPath)
+	result.OutputPath = fmt.Sprintf("%s_%d.ts", strings.TrimSuffix(outputPath, filepath.Ext(outputPath)), d.parts)
+	recorder.ReportOutputPath(ctx, result.OutputPath)
+
+	data := []byte(fmt.Sprintf("part %d;", d.parts))
+	result.Err = os.WriteFile(result.OutputPath, data, 0644)
+	result.Size = int64(len(data))
+	return result
+}
+
+func TestSession_ResumesSameLive(t *testing.T) {
+	rec := &droppingRecorder{fakeRecorder: &fakeRecorder{lives: fakePlatformRecorder.lives}, stillLive: 2}
+	d := &partDownloader{}
+	outputPath := filepath.Join(t.TempDir(), "fake_user.ts")
+
+	s := session.Record(context.Background(), rec, d, fakePlatformRecorder.lives[0], outputPath, &session.Options{ResumeDelay: 10 * time.Millisecond})
+	assert.NoError(t, s.Result.Err, "Failed to record session")
+	assert.Len(t, s.Parts, 3, "Recording should resume twice before the live ends")
+	assert.Greater(t, s.Gaps(), time.Duration(0))
+
+	data, err := os.ReadFile(s.Result.OutputPath)
+	assert.NoError(t, err, "Failed to read merged output")
+	assert.Equal(t, "part 1;part 2;part 3;", string(data))
+	assert.Equal(t, int64(len(data)), s.Result.Size)
+
+	_, err = os.Stat(s.Parts[1].Result.OutputPath)
+	assert.True(t, os.IsNotExist(err), "Merged parts should be removed")
+}
+
+func TestSession_SameLive(t *testing.T) {
+	startedAt := time.Now()
+	otherStart := startedAt.Add(time.Hour)
+	a := &recorder.Live{ID: "1", Platform: recorder.PlatformShowroom, StartedAt: &startedAt}
+
+	assert.True(t, session.SameLive(a, &recorder.Live{ID: "1", Platform: recorder.PlatformShowroom, StartedAt: &startedAt}))
+	assert.False(t, session.SameLive(a, &recorder.Live{ID: "1", Platform: recorder.PlatformShowroom, StartedAt: &otherStart}), "Same room started again is a new live")
+	assert.False(t, session.SameLive(a, &recorder.Live{ID: "2", Platform: recorder.PlatformShowroom}))
+	assert.False(t, session.SameLive(a, nil))
+}
+
+// splittingDownloader ends every download at the split limit
+type splittingDownloader struct {
+	partDownloader
+}
+
+func (d *splittingDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	result := d.partDownloader.Download(ctx, url, outputPath)
+	result.Split = true
+	return result
+}
+
+func TestSession_Split(t *testing.T) {
+	rec := &droppingRecorder{fakeRecorder: &fakeRecorder{lives: fakePlatformRecorder.lives}, stillLive: 2}
+	d := &splittingDownloader{}
+	outputPath := filepath.Join(t.TempDir(), "fake_user.ts")
+
+	s := session.Record(context.Background(), rec, d, fakePlatformRecorder.lives[0], outputPath, &session.Options{ResumeDelay: time.Hour})
+	assert.NoError(t, s.Result.Err, "Failed to record session")
+	assert.Len(t, s.Outputs, 3, "Split parts should not be merged")
+
+	for i, output := range s.Outputs {
+		data, err := os.ReadFile(output.OutputPath)
+		assert.NoError(t, err, "Failed to read part")
+		assert.Equal(t, fmt.Sprintf("part %d;", i+1), string(data))
+
+		metadata, err := os.ReadFile(session.MetadataPath(output.OutputPath))
+		assert.NoError(t, err, "Every part should have its metadata")
+		assert.Contains(t, string(metadata), fmt.Sprintf(`"part": %d`, i+1))
+	}
+}
+
+// flakyDownloader fails its second download and records the sidecars found while downloading
+type flakyDownloader struct {
+	partDownloader
+	calls    int
+	sidecars []string
+	progress []*session.Metadata
+}
+
+func (d *flakyDownloader) readSidecars(dir string) {
+	sidecars, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, sidecar := range sidecars {
+		if metadata, err := session.ReadMetadata(strings.TrimSuffix(sidecar, ".json")); err == nil {
+			d.sidecars = append(d.sidecars, filepath.Base(sidecar))
+			d.progress = append(d.progress, metadata)
+		}
+	}
+}
+
+func (d *flakyDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	d.calls++
+	if d.calls == 2 {
+		d.readSidecars(filepath.Dir(outputPath))
+		return &recorder.DownloadResult{URL: url, StartedAt: time.Now(), CompletedAt: time.Now(), ExitCode: 1, Err: errors.New("connection reset")}
+	}
+	// The sidecar is written once the downloader tells where its output goes
+	reportCtx := recorder.WithOutputPath(ctx, func(path string) {
+		recorder.ReportOutputPath(ctx, path)
+		d.readSidecars(filepath.Dir(outputPath))
+	})
+	return d.partDownloader.Download(reportCtx, url, outputPath)
+}
+
+func TestSession_Metadata(t *testing.T) {
+	live := *fakePlatformRecorder.lives[0]
+	live.Quality = "1080p"
+	rec := &droppingRecorder{fakeRecorder: &fakeRecorder{lives: fakePlatformRecorder.lives}, stillLive: 2}
+	d := &flakyDownloader{}
+	outputPath := filepath.Join(t.TempDir(), "fake_user.ts")
+
+	s := session.Record(context.Background(), rec, d, &live, outputPath, &session.Options{ResumeDelay: time.Millisecond})
+	assert.NoError(t, s.Result.Err, "Failed to record session")
+
+	if assert.Len(t, d.progress, 3, "Every download should find the sidecar of the file being recorded") {
+		assert.Equal(t, session.MetadataStatusRecording, d.progress[0].Status)
+		assert.Equal(t, "fake_user_1.ts.json", d.sidecars[0], "The sidecar should be next to the file the downloader writes")
+		assert.Equal(t, "1080p", d.progress[0].Quality)
+		assert.Len(t, d.progress[2].Downloads, 2, "The sidecar should be updated after every download")
+		assert.Equal(t, []string{"connection reset"}, d.progress[2].Errors)
+	}
+
+	metadata, err := session.ReadMetadata(s.Result.OutputPath)
+	assert.NoError(t, err, "Failed to read metadata")
+	assert.Equal(t, session.MetadataStatusCompleted, metadata.Status)
+	assert.Equal(t, live.ID, metadata.Live.ID)
+	assert.Equal(t, live.Streamer.Username, metadata.Live.Streamer.Username)
+	assert.Equal(t, 1, metadata.Parts)
+	assert.Len(t, metadata.Downloads, 3)
+	assert.Equal(t, []string{"connection reset"}, metadata.Errors)
+	assert.Equal(t, s.Result.Size, metadata.Size)
+	assert.NotNil(t, metadata.CompletedAt)
+
+	sidecars, _ := filepath.Glob(filepath.Join(filepath.Dir(outputPath), "*.json"))
+	assert.Len(t, sidecars, 1, "Only the merged output should have a sidecar")
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/agilistikmal/live-recorder/pkg/recorder/showroom"
 	"github.com/agilistikmal/live-recorder/utils"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestShowroomLiveService_Download(t *testing.T) {
@@ -31,10 +32,65 @@ func TestShowroomLiveService_Download(t *testing.T) {
 
 	t.Logf("Downloading 5 seconds HLS...")
 	go func() {
-		downloadInfo := utils.DownloadHLS(streamingUrl, &outputPath)
-		if downloadInfo == nil {
-			t.Log("Failed to download HLS")
+		downloadResult := utils.DownloadHLS(streamingUrl, &outputPath)
+		if downloadResult.Err != nil {
+			t.Logf("Failed to download HLS: %v", downloadResult.Err)
 		}
-		t.Logf("Download completed: %v", downloadInfo)
+		t.Logf("Download completed: %+v", downloadResult)
 	}()
 }
+
+func TestShowroomLiveService_GetLive(t *testing.T) {
+	showroomRecorder := showroom.NewRecorder()
+	showroomLives, err := showroomRecorder.GetLives()
+	if err != nil {
+		t.Fatalf("Failed to get showroom lives: %v", err)
+	}
+	if len(showroomLives) < 1 {
+		t.Fatalf("No showroom lives found")
+	}
+
+	live, err := showroomRecorder.GetLive(showroomLives[0].PlatformUrl)
+	if err != nil {
+		t.Fatalf("Failed to get showroom live: %v", err)
+	}
+
+	t.Logf("live: %v (%v)", live.Title, live.StreamingUrl)
+	if live.ID != showroomLives[0].ID {
+		t.Fatalf("Expected room id %v, got %v", showroomLives[0].ID, live.ID)
+	}
+	if live.StreamingUrl == "" {
+		t.Fatalf("Streaming url is empty")
+	}
+}
+
+func TestShowroom_ToLiveWithoutStart(t *testing.T) {
+	profile := &showroom.ShowroomRoomProfile{RoomID: 1, RoomUrlKey: "room", IsOnLive: true}
+	live := profile.ToLive("title")
+	assert.Nil(t, live.StartedAt, "A start time of 0 means the API has none")
+
+	profile.CurrentLiveStartedAt = 1714570000
+	live = profile.ToLive("title")
+	if assert.NotNil(t, live.StartedAt) {
+		assert.Equal(t, int64(1714570000), live.StartedAt.Unix())
+	}
+}
+
+func TestShowroom_ToLiveQuality(t *testing.T) {
+	showroomLive := &showroom.ShowroomLive{
+		RoomID:     1,
+		RoomUrlKey: "room",
+		StreamingUrlList: []showroom.ShowroomStreamingUrl{
+			{Label: "original", Url: "https://example.com/original.m3u8"},
+			{Label: "high", Url: "https://example.com/high.m3u8"},
+		},
+	}
+	live := showroomLive.ToLive()
+	assert.Equal(t, "https://example.com/high.m3u8", live.StreamingUrl)
+	assert.Equal(t, "high", live.Quality, "The quality should be the label of the url")
+
+	showroomLive.StreamingUrlList = nil
+	live = showroomLive.ToLive()
+	assert.Empty(t, live.StreamingUrl)
+	assert.Empty(t, live.Quality)
+}
@@ -0,0 +1,199 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+func storeRecord(username string, status watch.RecordingStatus, startedAt time.Time) *watch.Record {
+	live := &recorder.Live{ID: username, Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: username}}
+	key := watch.NewRecordingKey(live)
+	return &watch.Record{
+		ID:        key.String(),
+		Key:       key,
+		Live:      live,
+		Status:    status,
+		QueuedAt:  startedAt,
+		StartedAt: startedAt,
+		UpdatedAt: time.Now(),
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.jsonl")
+	store, err := watch.NewFileStore(path)
+	if !assert.NoError(t, err, "Failed to open store") {
+		return
+	}
+
+	now := time.Now()
+	old := storeRecord("old", watch.StatusCompleted, now.Add(-40*24*time.Hour))
+	recent := storeRecord("recent", watch.StatusInProgress, now.Add(-time.Hour))
+	assert.NoError(t, store.Save(old))
+	assert.NoError(t, store.Save(recent))
+
+	done := *recent
+	done.Status = watch.StatusFailed
+	done.Error = "connection reset"
+	done.UpdatedAt = time.Now()
+	assert.NoError(t, store.Save(&done))
+	stale := *recent
+	stale.UpdatedAt = now.Add(-time.Minute)
+	assert.NoError(t, store.Save(&stale), "A stale save is ignored")
+	assert.NoError(t, store.Close())
+
+	// A line cut short by a crash
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"id":"idn/cut`)
+	file.Close()
+
+	store, err = watch.NewFileStore(path)
+	if !assert.NoError(t, err, "Failed to reopen store") {
+		return
+	}
+	defer store.Close()
+
+	records, err := store.Load()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "old", records[0].Key.Streamer, "Records load oldest first")
+		assert.Equal(t, watch.StatusFailed, records[1].Status, "The last save of a record wins")
+		assert.Equal(t, "connection reset", records[1].Error)
+	}
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, 2, countLines(data), "The file is compacted when opened")
+
+	records, err = store.Query(&watch.HistoryQuery{Since: now.Add(-30 * 24 * time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1, "Only recordings of the last 30 days")
+	records, _ = store.Query(&watch.HistoryQuery{Streamer: "idn/old"})
+	assert.Len(t, records, 1)
+	records, _ = store.Query(&watch.HistoryQuery{Limit: 1})
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "recent", records[0].Key.Streamer, "Queries return the most recent first")
+	}
+}
+
+func countLines(data []byte) int {
+	lines := 0
+	for _, b := range data {
+		if b == '\n' {
+			lines++
+		}
+	}
+	return lines
+}
+
+func TestWatchLive_Store(t *testing.T) {
+	lives := []*recorder.Live{
+		{ID: "1", Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "foo"}},
+		{ID: "2", Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "bar"}},
+	}
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	newWatch := func(d recorder.Downloader) (*watch.WatchLive, *watch.FileStore) {
+		store, err := watch.NewFileStore(path)
+		assert.NoError(t, err, "Failed to open store")
+		watchService := watch.NewWatchLive(&fakeRecorder{lives: lives, ended: true}, t.TempDir())
+		watchService.SetDownloader(d)
+		watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond})
+		watchService.SetProgressInterval(-1)
+		assert.NoError(t, watchService.SetStore(store), "Failed to restore state")
+		return watchService, store
+	}
+
+	d := &fakeDownloader{}
+	watchService, store := newWatch(d)
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	assert.Len(t, d.downloads, 2)
+
+	// foo was still recording when the previous run stopped
+	records, _ := store.Query(&watch.HistoryQuery{Streamer: "foo"})
+	if assert.Len(t, records, 1) {
+		interrupted := *records[0]
+		interrupted.Status = watch.StatusInProgress
+		interrupted.CompletedAt = nil
+		interrupted.UpdatedAt = time.Now()
+		assert.NoError(t, store.Save(&interrupted))
+	}
+	store.Close()
+
+	d = &fakeDownloader{}
+	watchService, store = newWatch(d)
+	defer store.Close()
+	assert.Len(t, watchService.GetHistory(), 2, "The history is restored")
+	info, exists := watchService.GetStatus("foo")
+	assert.True(t, exists)
+	assert.Equal(t, watch.StatusFailed, info.Status)
+	assert.ErrorIs(t, info.Error, watch.ErrInterrupted)
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	assert.Len(t, d.downloads, 1, "Only the interrupted live is recorded again")
+
+	history, err := watchService.QueryHistory(&watch.HistoryQuery{Streamer: "foo", Since: time.Now().Add(-30 * 24 * time.Hour)})
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, watch.StatusCompleted, history[0].Status, "The most recent recording comes first")
+		assert.Len(t, history[0].Attempts, 1, "Attempts are stored")
+		assert.ErrorIs(t, history[1].Error, watch.ErrInterrupted)
+	}
+	history, _ = watchService.QueryHistory(&watch.HistoryQuery{Streamer: "bar"})
+	if assert.Len(t, history, 1) {
+		assert.Len(t, history[0].Parts, 1, "Outputs are stored")
+	}
+}
+
+func TestFileStore_Lock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	store, err := watch.NewFileStore(path)
+	if !assert.NoError(t, err, "Failed to open store") {
+		return
+	}
+	assert.NoError(t, store.Save(storeRecord("foo", watch.StatusCompleted, time.Now())))
+	before, _ := os.ReadFile(path)
+
+	// Reading while the store is open leaves the file alone, so later saves land in it
+	loaded, err := watch.LoadFileStore(path)
+	assert.NoError(t, err, "Failed to load store")
+	records, _ := loaded.Load()
+	assert.Len(t, records, 1)
+	assert.ErrorIs(t, loaded.Save(storeRecord("bar", watch.StatusCompleted, time.Now())), watch.ErrReadOnly)
+	after, _ := os.ReadFile(path)
+	assert.Equal(t, before, after, "Loading should not rewrite the file")
+
+	assert.NoError(t, store.Save(storeRecord("bar", watch.StatusCompleted, time.Now())))
+	loaded, _ = watch.LoadFileStore(path)
+	records, _ = loaded.Load()
+	assert.Len(t, records, 2, "Saves after a load should be kept")
+	assert.NoError(t, store.Close())
+	assert.NoFileExists(t, path+".lock", "Closing should release the lock")
+
+	// A running process holds the lock
+	os.WriteFile(path+".lock", []byte(strconv.Itoa(os.Getppid())), 0644)
+	_, err = watch.NewFileStore(path)
+	assert.ErrorIs(t, err, watch.ErrStoreLocked)
+
+	// A lock with the pid of this process was left by a previous run
+	os.WriteFile(path+".lock", []byte(strconv.Itoa(os.Getpid())), 0644)
+	store, err = watch.NewFileStore(path)
+	if assert.NoError(t, err, "A stale lock should be taken over") {
+		store.Close()
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.jsonl")
+	loaded, err = watch.LoadFileStore(missing)
+	assert.NoError(t, err)
+	records, _ = loaded.Load()
+	assert.Empty(t, records)
+	assert.NoFileExists(t, missing, "Loading should not create the file")
+}
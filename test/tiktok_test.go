@@ -3,6 +3,7 @@ package test
 import (
 	"testing"
 
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
 	"github.com/agilistikmal/live-recorder/pkg/recorder/tiktok"
 	"github.com/stretchr/testify/assert"
 )
@@ -41,3 +42,17 @@ func TestTiktokLiveService_Record(t *testing.T) {
 	assert.NoError(t, err, "Failed to record tiktok live")
 	t.Logf("Live recorded to: %s", outputPath)
 }
+
+func TestTiktokLiveService_GetLives(t *testing.T) {
+	tiktokRecorder := tiktok.NewRecorder()
+	tiktokRecorder.(recorder.WatchListRecorder).SetWatchList([]string{"kucing_lusuh", "bossdikha"})
+
+	lives, err := tiktokRecorder.GetLives()
+	assert.NoError(t, err, "Failed to get tiktok lives")
+
+	for _, live := range lives {
+		assert.Equal(t, recorder.PlatformTiktok, live.Platform)
+		assert.NotEmpty(t, live.StreamingUrl, "Live streaming url is empty")
+		t.Logf("Live: %s - %s", live.Streamer.Username, live.Title)
+	}
+}
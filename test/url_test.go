@@ -0,0 +1,45 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/live"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectPlatform(t *testing.T) {
+	cases := map[string]string{
+		"https://www.tiktok.com/@kucing_lusuh/live":            recorder.PlatformTiktok,
+		"https://tiktok.com/@user.name/live?lang=en":           recorder.PlatformTiktok,
+		"https://www.showroom-live.com/r/48_Amanda":            recorder.PlatformShowroom,
+		"https://showroom-live.com/r/officialJKT48/":           recorder.PlatformShowroom,
+		"https://www.idn.app/jkt48_freya/live/live-freya-1234": recorder.PlatformIDN,
+		"https://www.idn.app/jkt48_freya/live":                 recorder.PlatformIDN,
+	}
+
+	for url, expected := range cases {
+		platform, err := recorder.DetectPlatform(url)
+		assert.NoError(t, err, "Failed to detect platform for %s", url)
+		assert.Equal(t, expected, platform, "Wrong platform for %s", url)
+	}
+}
+
+func TestDetectPlatform_Unsupported(t *testing.T) {
+	for _, url := range []string{
+		"https://www.youtube.com/watch?v=abc",
+		"https://www.tiktok.com/@user",
+		"https://www.showroom-live.com/onlive",
+	} {
+		_, err := recorder.DetectPlatform(url)
+		assert.ErrorIs(t, err, recorder.ErrUnsupportedURL, "Expected unsupported URL for %s", url)
+	}
+}
+
+func TestLiveRecorder_GetLiveUnsupportedURL(t *testing.T) {
+	liveRecorder := live.NewRecorder(&recorder.LiveQuery{
+		Platforms: []string{recorder.PlatformShowroom},
+	})
+	_, err := liveRecorder.GetLive("https://example.com/live")
+	assert.ErrorIs(t, err, recorder.ErrUnsupportedURL)
+}
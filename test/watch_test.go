@@ -0,0 +1,411 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/session"
+	"github.com/agilistikmal/live-recorder/pkg/recorder/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingDownloader downloads until ctx is cancelled, then takes stopDelay to finalize.
+// Every value sent to release ends one download as if its stream ended.
+type blockingDownloader struct {
+	fakeDownloader
+	stopDelay time.Duration
+	release   chan struct{}
+}
+
+func (d *blockingDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	select {
+	case <-ctx.Done():
+	case <-d.release:
+	}
+	time.Sleep(d.stopDelay)
+	return d.fakeDownloader.Download(context.Background(), url, outputPath)
+}
+
+func newBlockingWatch(t *testing.T, stopDelay time.Duration) *watch.WatchLive {
+	watchService := watch.NewWatchLive(&fakeRecorder{lives: fakePlatformRecorder.lives}, t.TempDir())
+	watchService.SetDownloader(&blockingDownloader{stopDelay: stopDelay})
+	watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond})
+	return watchService
+}
+
+func TestWatchLive_GracefulShutdown(t *testing.T) {
+	watchService := newBlockingWatch(t, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchService.CheckAndStartRecordingContext(ctx)
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	assert.NoError(t, watchService.WaitContext(waitCtx), "Recording should finalize before the deadline")
+
+	info, exists := watchService.GetStatus("fake_user")
+	assert.True(t, exists)
+	assert.Equal(t, watch.StatusCompleted, info.Status, "A stopped recording keeps what was captured")
+}
+
+func TestWatchLive_ShutdownDeadline(t *testing.T) {
+	watchService := newBlockingWatch(t, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchService.CheckAndStartRecordingContext(ctx)
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer waitCancel()
+	assert.ErrorIs(t, watchService.WaitContext(waitCtx), context.DeadlineExceeded)
+	watchService.Wait()
+}
+
+// progressDownloader reports a few seconds of progress before the stream ends
+type progressDownloader struct {
+	fakeDownloader
+}
+
+func (d *progressDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	meter := recorder.NewProgressMeter(ctx, url, outputPath)
+	for i := 1; i <= 3; i++ {
+		time.Sleep(time.Millisecond)
+		meter.Update(time.Duration(i)*time.Second, int64(i)*1000, i)
+	}
+	return d.fakeDownloader.Download(ctx, url, outputPath)
+}
+
+func TestWatchLive_Progress(t *testing.T) {
+	statusChan := make(chan *watch.StatusUpdate, 10)
+	watchService := watch.NewWatchLive(&fakeRecorder{lives: fakePlatformRecorder.lives, ended: true}, t.TempDir())
+	watchService.SetDownloader(&progressDownloader{})
+	watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond})
+	watchService.SetStatusChannel(statusChan)
+	watchService.SetProgressInterval(time.Nanosecond)
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	close(statusChan)
+
+	var progress []*recorder.Progress
+	for update := range statusChan {
+		if update.Progress != nil {
+			assert.Equal(t, watch.StatusInProgress, update.Status)
+			progress = append(progress, update.Progress)
+		}
+	}
+	if assert.Len(t, progress, 3) {
+		assert.Equal(t, 3*time.Second, progress[2].Elapsed)
+		assert.Equal(t, int64(3000), progress[2].Size)
+		assert.Equal(t, float64(8000), progress[2].Bitrate)
+	}
+
+	info, _ := watchService.GetStatus("fake_user")
+	assert.Equal(t, watch.StatusCompleted, info.Status)
+	assert.NotNil(t, info.Progress, "The last progress is kept on the recording")
+}
+
+func TestWatchLive_Rearm(t *testing.T) {
+	first := *fakePlatformRecorder.lives[0]
+	rec := &fakeRecorder{lives: []*recorder.Live{&first}, ended: true}
+	d := &fakeDownloader{}
+	watchService := watch.NewWatchLive(rec, t.TempDir())
+	watchService.SetDownloader(d)
+	watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond})
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	assert.Len(t, d.downloads, 1, "A live still listed after its recording ended should not be recorded again")
+
+	second := first
+	second.ID = "fake-2"
+	rec.lives = []*recorder.Live{&second}
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	assert.Len(t, d.downloads, 2, "A new live of the same streamer should be recorded")
+
+	history := watchService.GetHistory()
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "fake-1", history[0].Live.ID)
+		assert.Equal(t, "fake-2", history[1].Live.ID)
+	}
+	info, exists := watchService.GetStatus("fake_user")
+	assert.True(t, exists)
+	assert.Equal(t, "fake-2", info.Live.ID, "The status of a streamer is its last recording")
+	assert.Len(t, watchService.GetStatusesByStatus(watch.StatusCompleted), 2)
+}
+
+func TestWatchLive_RunningDuplicate(t *testing.T) {
+	d := &blockingDownloader{}
+	watchService := watch.NewWatchLive(&fakeRecorder{lives: fakePlatformRecorder.lives}, t.TempDir())
+	watchService.SetDownloader(d)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchService.CheckAndStartRecordingContext(ctx)
+	watchService.CheckAndStartRecordingContext(ctx)
+	assert.Len(t, watchService.GetStatusesByStatus(watch.StatusInProgress), 1, "A running recording should not be started twice")
+	cancel()
+	watchService.Wait()
+	assert.Len(t, d.downloads, 1)
+}
+
+func TestWatchLive_RecordingKeys(t *testing.T) {
+	startedAt := time.Unix(1714570000, 0)
+	lives := []*recorder.Live{
+		{ID: "1", Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "foo"}},
+		{ID: "2", Platform: recorder.PlatformShowroom, Streamer: &recorder.LiveStreamer{Username: "foo"}, StartedAt: &startedAt},
+		{ID: "3", Platform: recorder.PlatformTiktok}, // No streamer
+	}
+	d := &fakeDownloader{}
+	statusChan := make(chan *watch.StatusUpdate, 10)
+	watchService := watch.NewWatchLive(&fakeRecorder{lives: lives, ended: true}, t.TempDir())
+	watchService.SetDownloader(d)
+	watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond})
+	watchService.SetStatusChannel(statusChan)
+	watchService.SetProgressInterval(-1)
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	close(statusChan)
+	assert.Len(t, d.downloads, 3, "The same username on two platforms should not block each other")
+
+	var ids []string
+	for update := range statusChan {
+		if update.Status == watch.StatusCompleted {
+			ids = append(ids, update.StreamerID)
+		}
+	}
+	assert.ElementsMatch(t, []string{"idn/foo/1", "showroom/foo/2@1714570000", "tiktok/3/3"}, ids)
+
+	info, exists := watchService.GetStatus("showroom/foo")
+	assert.True(t, exists)
+	assert.Equal(t, "2", info.Live.ID)
+	info, exists = watchService.GetStatus("idn/foo/1")
+	assert.True(t, exists)
+	assert.Equal(t, "1", info.Live.ID)
+	_, exists = watchService.GetStatus("foo")
+	assert.True(t, exists, "A bare username matches the streamer on any platform")
+	assert.Len(t, watchService.GetAllStatuses(), 3)
+}
+
+func TestWatchLive_Limits(t *testing.T) {
+	lives := []*recorder.Live{
+		{ID: "1", Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "small", FollowerCount: 10}},
+		{ID: "2", Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "big", FollowerCount: 100}},
+		{ID: "3", Platform: recorder.PlatformShowroom, Streamer: &recorder.LiveStreamer{Username: "other", FollowerCount: 1}},
+		{ID: "4", Platform: recorder.PlatformShowroom, Streamer: &recorder.LiveStreamer{Username: "late", FollowerCount: 1}},
+	}
+	watchService := watch.NewWatchLive(&fakeRecorder{lives: lives}, t.TempDir())
+	watchService.SetDownloader(&blockingDownloader{})
+	watchService.SetLimits(&watch.Limits{Max: 2, PerPlatform: map[string]int{recorder.PlatformIDN: 1}})
+	watchService.SetPriority(watch.ByFollowers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchService.CheckAndStartRecordingContext(ctx)
+
+	var running []string
+	for _, info := range watchService.GetStatusesByStatus(watch.StatusInProgress) {
+		running = append(running, info.Key.Streamer)
+	}
+	assert.ElementsMatch(t, []string{"big", "other"}, running, "The idn limit leaves the second slot to showroom")
+
+	queued := watchService.GetStatusesByStatus(watch.StatusQueued)
+	assert.Len(t, queued, 2)
+	for _, info := range queued {
+		assert.True(t, info.StartedAt.IsZero())
+		assert.False(t, info.QueuedAt.IsZero())
+	}
+
+	cancel()
+	watchService.Wait()
+	assert.Len(t, watchService.GetStatusesByStatus(watch.StatusQueued), 2, "Nothing starts once the watch is stopped")
+}
+
+func TestWatchLive_Preempt(t *testing.T) {
+	regular := &recorder.Live{ID: "1", Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "regular"}}
+	vip := &recorder.Live{ID: "2", Platform: recorder.PlatformIDN, Streamer: &recorder.LiveStreamer{Username: "vip"}}
+	rec := &fakeRecorder{lives: []*recorder.Live{regular}}
+	d := &blockingDownloader{release: make(chan struct{})}
+	watchService := watch.NewWatchLive(rec, t.TempDir())
+	watchService.SetDownloader(d)
+	watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond})
+	watchService.SetLimits(&watch.Limits{Max: 1, Preempt: true})
+	watchService.SetPriority(watch.ByStreamers([]string{"idn/vip"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watchService.CheckAndStartRecordingContext(ctx)
+	info, _ := watchService.GetStatus("regular")
+	assert.Equal(t, watch.StatusInProgress, info.Status)
+
+	rec.lives = []*recorder.Live{regular, vip}
+	watchService.CheckAndStartRecordingContext(ctx)
+	assert.Eventually(t, func() bool {
+		running := watchService.GetStatusesByStatus(watch.StatusInProgress)
+		return len(running) == 1 && running[0].Key.Streamer == "vip"
+	}, time.Second, 5*time.Millisecond, "The vip should take the slot of the regular streamer")
+
+	info, _ = watchService.GetStatus("regular")
+	assert.Equal(t, watch.StatusQueued, info.Status, "A preempted recording waits for the next free slot")
+	history := watchService.GetHistory()
+	if assert.Len(t, history, 1) {
+		assert.True(t, history[0].Preempted)
+		assert.Equal(t, watch.StatusCompleted, history[0].Status, "A preempted recording keeps what it captured")
+	}
+
+	// The regular streamer resumes once the live of the vip ends
+	rec.lives = []*recorder.Live{regular}
+	d.release <- struct{}{}
+	assert.Eventually(t, func() bool {
+		running := watchService.GetStatusesByStatus(watch.StatusInProgress)
+		return len(running) == 1 && running[0].Key.Streamer == "regular"
+	}, time.Second, 5*time.Millisecond, "The requeued recording should take the freed slot")
+	completed := watchService.GetStatusesByStatus(watch.StatusCompleted)
+	if assert.Len(t, completed, 2) {
+		assert.Equal(t, "vip", completed[1].Key.Streamer)
+	}
+
+	cancel()
+	watchService.Wait()
+	assert.Len(t, d.downloads, 3)
+}
+
+// failingDownloader fails its first failures downloads
+type failingDownloader struct {
+	fakeDownloader
+	failures int
+}
+
+func (d *failingDownloader) Download(ctx context.Context, url string, outputPath string) *recorder.DownloadResult {
+	d.mu.Lock()
+	fail := d.failures > 0
+	d.failures--
+	d.mu.Unlock()
+	if fail {
+		return &recorder.DownloadResult{URL: url, StartedAt: time.Now(), CompletedAt: time.Now(), ExitCode: 1, Err: errors.New("connection reset")}
+	}
+	return d.fakeDownloader.Download(ctx, url, outputPath)
+}
+
+func newRetryWatch(t *testing.T, rec recorder.Recorder, failures int, maxRetries int) (*watch.WatchLive, chan *watch.StatusUpdate) {
+	statusChan := make(chan *watch.StatusUpdate, 20)
+	watchService := watch.NewWatchLive(rec, t.TempDir())
+	watchService.SetDownloader(&failingDownloader{failures: failures})
+	watchService.SetSessionOptions(&session.Options{ResumeDelay: time.Millisecond, MaxFailedParts: 1})
+	watchService.SetRetryPolicy(&watch.RetryPolicy{MaxRetries: maxRetries, Delay: time.Millisecond})
+	watchService.SetStatusChannel(statusChan)
+	watchService.SetProgressInterval(-1)
+	return watchService, statusChan
+}
+
+func statuses(statusChan chan *watch.StatusUpdate) []watch.RecordingStatus {
+	close(statusChan)
+	var result []watch.RecordingStatus
+	for update := range statusChan {
+		result = append(result, update.Status)
+	}
+	return result
+}
+
+func TestWatchLive_Retry(t *testing.T) {
+	live := &recorder.Live{ID: "1", Platform: fakePlatform, PlatformUrl: "https://fake/retry", Streamer: &recorder.LiveStreamer{Username: "retry"}}
+	rec := &droppingRecorder{fakeRecorder: &fakeRecorder{lives: []*recorder.Live{live}}, stillLive: 2}
+	watchService, statusChan := newRetryWatch(t, rec, 2, 3)
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+
+	assert.Equal(t, []watch.RecordingStatus{
+		watch.StatusInProgress, watch.StatusRetrying,
+		watch.StatusInProgress, watch.StatusRetrying,
+		watch.StatusInProgress, watch.StatusCompleted,
+	}, statuses(statusChan))
+
+	info, exists := watchService.GetStatus("retry")
+	assert.True(t, exists)
+	assert.Equal(t, watch.StatusCompleted, info.Status)
+	assert.NoError(t, info.Error)
+	assert.Nil(t, info.RetryAt)
+	if assert.Len(t, info.Attempts, 3) {
+		assert.ErrorContains(t, info.Attempts[0].Err, "connection reset")
+		assert.Error(t, info.Attempts[1].Err)
+		assert.NoError(t, info.Attempts[2].Err)
+		assert.Equal(t, 3, info.Attempts[2].Number)
+	}
+}
+
+func TestWatchLive_RetryLimit(t *testing.T) {
+	live := &recorder.Live{ID: "1", Platform: fakePlatform, PlatformUrl: "https://fake/retry", Streamer: &recorder.LiveStreamer{Username: "retry"}}
+	rec := &droppingRecorder{fakeRecorder: &fakeRecorder{lives: []*recorder.Live{live}}, stillLive: 2}
+	watchService, statusChan := newRetryWatch(t, rec, 10, 2)
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+
+	updates := statuses(statusChan)
+	assert.Equal(t, watch.StatusFailed, updates[len(updates)-1])
+	info, _ := watchService.GetStatus("retry")
+	assert.Len(t, info.Attempts, 3, "The first attempt and two retries")
+	assert.ErrorContains(t, info.Error, "connection reset")
+}
+
+func TestWatchLive_RetryEnded(t *testing.T) {
+	live := &recorder.Live{ID: "1", Platform: fakePlatform, PlatformUrl: "https://fake/retry", Streamer: &recorder.LiveStreamer{Username: "retry"}}
+	watchService, statusChan := newRetryWatch(t, &fakeRecorder{lives: []*recorder.Live{live}, ended: true}, 10, 3)
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+
+	assert.Equal(t, []watch.RecordingStatus{watch.StatusInProgress, watch.StatusRetrying, watch.StatusFailed}, statuses(statusChan))
+	info, _ := watchService.GetStatus("retry")
+	assert.Len(t, info.Attempts, 1, "A live that ended is not retried")
+}
+
+func TestWatchLive_RetryConcurrentReads(t *testing.T) {
+	live := &recorder.Live{ID: "1", Title: "retry", Platform: fakePlatform, PlatformUrl: "https://fake/retry", Streamer: &recorder.LiveStreamer{Username: "retry"}}
+	rec := &droppingRecorder{fakeRecorder: &fakeRecorder{lives: []*recorder.Live{live}}, stillLive: 3}
+	watchService, _ := newRetryWatch(t, rec, 3, 3)
+	watchService.SetStatusChannel(nil)
+	watchService.SetRetryPolicy(&watch.RetryPolicy{MaxRetries: 3, Delay: 10 * time.Millisecond}) // Time for the reads to overlap
+	store, err := watch.NewFileStore(filepath.Join(t.TempDir(), "state.jsonl"))
+	if !assert.NoError(t, err, "Failed to open store") {
+		return
+	}
+	defer store.Close()
+	assert.NoError(t, watchService.SetStore(store))
+
+	done := make(chan struct{})
+	titles := make(chan string)
+	go func() {
+		title := ""
+		defer func() { titles <- title }()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			watchService.GetStatus("retry")
+			history, _ := watchService.QueryHistory(&watch.HistoryQuery{Streamer: "retry"})
+			for _, info := range history {
+				title = info.Live.Title
+			}
+		}
+	}()
+
+	watchService.CheckAndStartRecordingContext(context.Background())
+	watchService.Wait()
+	close(done)
+	assert.Equal(t, "retry", <-titles)
+
+	info, _ := watchService.GetStatus("retry")
+	assert.Equal(t, watch.StatusCompleted, info.Status)
+	assert.Len(t, info.Attempts, 4)
+}
@@ -1,110 +1,388 @@
 package utils
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/agilistikmal/live-recorder/pkg/hls"
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
 	"github.com/sirupsen/logrus"
 )
 
-func DownloadHLS(url string, outputPath *string) map[string]interface{} {
+// FFmpegStopTimeout is how long a cancelled ffmpeg gets to finalize its output before it is killed
+var FFmpegStopTimeout = 15 * time.Second
+
+func DownloadHLS(url string, outputPath *string) *recorder.DownloadResult {
+	return DownloadHLSContext(context.Background(), url, outputPath)
+}
+
+// DownloadOptions controls how a stream is written, nil means the defaults
+type DownloadOptions struct {
+	// Format is the output format, taken from the extension of the output path when empty
+	Format string
+	// RemuxMP4 remuxes a TS, MKV or fragmented MP4 output to a regular MP4 once the stream ends
+	RemuxMP4 bool
+	// SplitDuration and SplitSize stop the download once the output reaches them, zero means no limit
+	SplitDuration time.Duration
+	SplitSize     int64
+	// Metadata is written into the output, its tags while recording and its cover art by the final mux
+	Metadata *MediaMetadata
+}
+
+// DownloadHLSContext records the HLS stream at url until it ends or ctx is cancelled.
+// On cancellation ffmpeg is asked to quit so the captured part is finalized as usual.
+// The returned result is never nil, a failed download has its Err set
+// and still reports the OutputPath and Size of anything captured before the failure.
+func DownloadHLSContext(ctx context.Context, url string, outputPath *string) *recorder.DownloadResult {
+	return DownloadHLSWithOptions(ctx, url, outputPath, nil)
+}
+
+// DownloadHLSWithOptions is DownloadHLSContext with a choice of output format.
+// The output is written to outputPath with the extension of the format, or with a number
+// appended to the name when that file already exists, and outputPath is updated to it.
+// Every format is written so that the temporary file stays playable while recording
+// and after a crash, a regular MP4 is only produced by the final remux.
+func DownloadHLSWithOptions(ctx context.Context, url string, outputPath *string, opts *DownloadOptions) *recorder.DownloadResult {
+	result := &recorder.DownloadResult{
+		URL:       url,
+		StartedAt: time.Now(),
+		ExitCode:  -1,
+	}
+	fail := func(err error) *recorder.DownloadResult {
+		result.CompletedAt = time.Now()
+		result.Err = err
+		return result
+	}
+
+	o := DownloadOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Format == "" {
+		o.Format = FormatFromExt(*outputPath)
+	}
+	if !ValidFormat(o.Format) {
+		return fail(fmt.Errorf("invalid output format: %s", o.Format))
+	}
+
 	if _, err := os.Stat(filepath.Dir(*outputPath)); os.IsNotExist(err) {
 		os.MkdirAll(filepath.Dir(*outputPath), 0755)
 	}
 
-	ext := filepath.Ext(*outputPath)
-	outputPathWithoutExt := strings.TrimSuffix(*outputPath, ext)
+	// The output keeps its name with the extension of the format, an existing file is never overwritten
+	ext := FormatExt(o.Format)
+	outputPathFinal := UniquePath(strings.TrimSuffix(*outputPath, filepath.Ext(*outputPath)) + ext)
+	outputPathTemp := fmt.Sprintf("%s.%d.tmp%s", strings.TrimSuffix(outputPathFinal, ext), result.StartedAt.Unix(), ext)
+	recorder.ReportOutputPath(ctx, outputPathFinal)
 
-	timestamp := time.Now().Unix()
-	outputPathTemp := fmt.Sprintf("%s_%d.tmp%s", outputPathWithoutExt, timestamp, ext)
-
-	cmd := exec.Command("ffmpeg",
+	args := []string{
 		// "-t", "10", // For testing purposes (recording 10 seconds)
 		"-i", url,
 		"-y",
 		"-c", "copy",
-		"-bsf:a", "aac_adtstoasc",
-		"-movflags", "faststart",
-		outputPathTemp,
-	)
+	}
+	args = append(args, formatArgs(o.Format)...)
+	args = append(args, o.Metadata.tagArgs()...)
+	if o.SplitDuration > 0 {
+		args = append(args, "-t", strconv.FormatFloat(o.SplitDuration.Seconds(), 'f', 3, 64))
+	}
+	if o.SplitSize > 0 {
+		args = append(args, "-fs", strconv.FormatInt(o.SplitSize, 10))
+	}
+	stderr := &ffmpegLog{}
+	meter := recorder.NewProgressMeter(ctx, url, outputPathTemp)
+	if meter != nil {
+		args = append(args, "-progress", "pipe:1")
+	}
+	args = append(args, outputPathTemp)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = stderr
+	if meter != nil {
+		cmd.Stdout = &ffmpegProgress{meter: meter, log: stderr}
+	}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	// Sending "q" on stdin makes ffmpeg stop reading and write the trailer,
+	// unlike the default cancel which kills the process mid-write
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logrus.Errorf("Failed to open ffmpeg stdin: %v", err)
+		return fail(err)
+	}
+	cmd.Cancel = func() error {
+		_, err := io.WriteString(stdin, "q")
+		return err
+	}
+	cmd.WaitDelay = FFmpegStopTimeout
 
-	err := cmd.Run()
+	err = runFFmpeg(cmd)
+	result.Segments = stderr.Segments()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	// A dropped stream still leaves a usable part, it is finalized and returned along with the error
+	var runErr error
 	if err != nil {
-		logrus.Errorf("Failed to download HLS using ffmpeg: %v, stderr: %s", err, stderr.String())
-		return nil
+		if ctx.Err() == nil {
+			logrus.Errorf("Failed to download HLS using ffmpeg: %v, stderr: %s", err, stderr.String())
+			runErr = fmt.Errorf("ffmpeg exited with code %d: %w", result.ExitCode, err)
+		} else {
+			logrus.Infof("Recording stopped: %v", ctx.Err())
+			runErr = ctx.Err()
+		}
+		if fileInfo, statErr := os.Stat(outputPathTemp); statErr != nil || fileInfo.Size() == 0 {
+			logrus.Errorf("Recording ended before any data was written: %v", runErr)
+			os.Remove(outputPathTemp)
+			return fail(runErr)
+		}
+		if ctx.Err() != nil {
+			runErr = nil
+		}
+	}
+
+	// The name picked at the start may have been taken by another recording since
+	outputPathFinal, reserveErr := ReservePath(outputPathFinal)
+	if reserveErr != nil {
+		return fail(reserveErr)
+	}
+	*outputPath = outputPathFinal
+	result.OutputPath = outputPathFinal
+
+	// ffmpeg exits normally at a limit, which is told apart from the end of the stream by the output reaching it
+	if err == nil && ctx.Err() == nil {
+		if fileInfo, statErr := os.Stat(outputPathTemp); statErr == nil && o.SplitSize > 0 && fileInfo.Size() >= o.SplitSize {
+			result.Split = true
+		}
+		// -t limits media time, which runs behind wall time when ffmpeg starts slowly or the playlist stalls
+		if o.SplitDuration > 0 {
+			duration, probeErr := ProbeDuration(context.Background(), outputPathTemp)
+			if probeErr != nil && !errors.Is(probeErr, exec.ErrNotFound) {
+				logrus.Warnf("Failed to probe duration of %s: %v", outputPathTemp, probeErr)
+			}
+			if probeErr == nil && duration >= o.SplitDuration-time.Second {
+				result.Split = true
+			}
+		}
 	}
 
-	tempFiles, err := filepath.Glob(fmt.Sprintf("%s_*.tmp%s", outputPathWithoutExt, ext))
+	// Only this run's temp file is finalized, parts of earlier runs belong to their own session.
+	// A regular MP4 needs a remux to move the index to the front and Matroska one to attach the cover art,
+	// other formats are complete as written.
+	mux := o.Format == recorder.FormatMP4 || (o.Format == recorder.FormatMKV && !o.RemuxMP4 && o.Metadata.hasCover())
+	if mux {
+		err = JoinFilesWithMetadata(context.Background(), []string{outputPathTemp}, *outputPath, o.Metadata)
+		if err != nil && o.Format == recorder.FormatMKV {
+			// The Matroska file is complete without the cover
+			logrus.Warnf("Failed to mux %s, keeping it as recorded: %v", *outputPath, err)
+			mux = false
+		} else if err != nil {
+			logrus.Errorf("Failed to join files: %v", err)
+			os.Remove(*outputPath)
+			return fail(fmt.Errorf("failed to join files: %w", err))
+		} else {
+			os.Remove(outputPathTemp)
+		}
+	}
+	if !mux {
+		if err = os.Rename(outputPathTemp, *outputPath); err != nil {
+			os.Remove(*outputPath)
+			return fail(err)
+		}
+	}
+
+	if o.RemuxMP4 && o.Format != recorder.FormatMP4 {
+		remuxed, err := RemuxToMP4(context.Background(), *outputPath, o.Metadata)
+		if err != nil {
+			logrus.Warnf("Keeping %s as it is: %v", *outputPath, err)
+		}
+		*outputPath = remuxed
+		result.OutputPath = remuxed
+	}
+
+	fileInfo, err := os.Stat(*outputPath)
 	if err != nil {
-		logrus.Errorf("Failed to get temp files: %v", err)
-		return nil
+		logrus.Errorf("Failed to get file info: %v", err)
+		return fail(err)
+	}
+	result.Size = fileInfo.Size()
+
+	// A cancelled ctx must not prevent reading the duration of what was captured
+	duration, err := ProbeDuration(context.Background(), *outputPath)
+	if err != nil && !errors.Is(err, exec.ErrNotFound) {
+		logrus.Warnf("Failed to probe duration of %s: %v", *outputPath, err)
+	}
+	result.Duration = duration
+	result.CompletedAt = time.Now()
+	result.Err = runErr
+
+	return result
+}
+
+// JoinFiles concatenates the media files in inputs into outputPath without re-encoding.
+// MPEG-TS inputs are joined byte by byte when ffmpeg is not installed.
+func JoinFiles(ctx context.Context, inputs []string, outputPath string) error {
+	return JoinFilesWithMetadata(ctx, inputs, outputPath, nil)
+}
+
+// JoinFilesWithMetadata is JoinFiles writing the tags and cover art of metadata into the output.
+// Tags are lost when MPEG-TS inputs are joined without ffmpeg.
+func JoinFilesWithMetadata(ctx context.Context, inputs []string, outputPath string, metadata *MediaMetadata) error {
+	if len(inputs) == 0 {
+		return errors.New("no files to join")
 	}
 
-	sort.Strings(tempFiles)
+	ext := filepath.Ext(outputPath)
 
 	// File list for FFmpeg concat demuxer
-	listFilePath := outputPathWithoutExt + ".list"
+	listFilePath := strings.TrimSuffix(outputPath, ext) + ".list"
 	listContent := ""
-	for _, tempFile := range tempFiles {
-		listContent += fmt.Sprintf("file '%s'\n", filepath.Base(tempFile))
+	for _, input := range inputs {
+		absInput, err := filepath.Abs(input)
+		if err != nil {
+			return err
+		}
+		listContent += fmt.Sprintf("file '%s'\n", strings.ReplaceAll(absInput, "'", `'\''`))
 	}
-	err = os.WriteFile(listFilePath, []byte(listContent), 0644)
+	err := os.WriteFile(listFilePath, []byte(listContent), 0644)
 	if err != nil {
-		logrus.Errorf("Failed to write list file: %v", err)
+		return err
+	}
+	defer os.Remove(listFilePath)
+
+	return withCover(ctx, metadata, outputPath, func(coverPath string) error {
+		args := []string{
+			"-f", "concat",
+			"-safe", "0",
+			"-i", listFilePath,
+		}
+		var coverOutputArgs []string
+		if coverPath != "" {
+			var coverInputArgs []string
+			coverInputArgs, coverOutputArgs = coverArgs(coverPath, ext)
+			args = append(args, coverInputArgs...)
+		}
+		args = append(args, "-y", "-c", "copy")
+		args = append(args, coverOutputArgs...)
+		args = append(args, metadata.tagArgs()...)
+		if ext == ".mp4" {
+			args = append(args, "-bsf:a", "aac_adtstoasc", "-movflags", "faststart")
+		}
+		args = append(args, outputPath)
+
+		cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+		stderr := &ffmpegLog{}
+		cmd.Stderr = stderr
+		err := runFFmpeg(cmd)
+		if errors.Is(err, exec.ErrNotFound) && ext == ".ts" {
+			return joinTS(inputs, outputPath)
+		}
+		if err != nil {
+			return fmt.Errorf("%w, stderr: %s", err, stderr.String())
+		}
 		return nil
+	})
+}
+
+// joinTS appends MPEG-TS files, which stay playable when simply concatenated
+func joinTS(inputs []string, outputPath string) error {
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return err
 	}
+	defer output.Close()
 
-	outputPathFinal := fmt.Sprintf("%s_%d%s", outputPathWithoutExt, timestamp, ext)
-	*outputPath = outputPathFinal
+	for _, input := range inputs {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(output, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return output.Close()
+}
 
-	// Joining Files to Output
-	cmd = exec.Command("ffmpeg",
-		"-f", "concat",
-		"-safe", "0",
-		"-i", listFilePath,
-		"-y",
-		"-c", "copy",
-		"-bsf:a", "aac_adtstoasc",
-		"-movflags", "faststart",
-		*outputPath,
-	)
+func DownloadHLSNative(url string, outputPath *string) *recorder.DownloadResult {
+	return DownloadHLSNativeContext(context.Background(), url, outputPath)
+}
 
-	err = cmd.Run()
+// DownloadHLSNativeContext records the HLS stream at url with the pure Go engine instead of ffmpeg.
+// The output is written as MPEG-TS as segments arrive, so the extension of outputPath becomes .ts
+// and the file stays playable even if the process dies mid-recording.
+// An existing file is never overwritten, a number is appended to the name instead.
+func DownloadHLSNativeContext(ctx context.Context, url string, outputPath *string) *recorder.DownloadResult {
+	return DownloadHLSNativeWithOptions(ctx, url, outputPath, nil)
+}
 
-	// Cleanup temporary files
-	os.Remove(listFilePath)
-	for _, tempFile := range tempFiles {
-		os.Remove(tempFile)
+// DownloadHLSNativeWithOptions is DownloadHLSNativeContext with split limits, the format is always MPEG-TS
+func DownloadHLSNativeWithOptions(ctx context.Context, url string, outputPath *string, opts *DownloadOptions) *recorder.DownloadResult {
+	result := &recorder.DownloadResult{
+		URL:       url,
+		StartedAt: time.Now(),
+		ExitCode:  -1,
 	}
 
-	if err != nil {
-		logrus.Errorf("Failed to join files: %v", err)
-		return nil
+	hlsOptions := &hls.Options{}
+	if opts != nil {
+		hlsOptions.MaxBytes = opts.SplitSize
+		hlsOptions.MaxDuration = opts.SplitDuration
 	}
 
-	fileInfo, err := os.Stat(*outputPath)
+	if err := os.MkdirAll(filepath.Dir(*outputPath), 0755); err != nil {
+		result.CompletedAt = time.Now()
+		result.Err = err
+		return result
+	}
+	file, err := CreateUnique(strings.TrimSuffix(*outputPath, filepath.Ext(*outputPath)) + ".ts")
 	if err != nil {
-		logrus.Errorf("Failed to get file info: %v", err)
-		return nil
+		result.CompletedAt = time.Now()
+		result.Err = err
+		return result
+	}
+	defer file.Close()
+	*outputPath = file.Name()
+	result.OutputPath = *outputPath
+	recorder.ReportOutputPath(ctx, *outputPath)
+
+	if meter := recorder.NewProgressMeter(ctx, url, *outputPath); meter != nil {
+		hlsOptions.Progress = func(stats hls.Stats) {
+			meter.Update(stats.Duration, stats.Bytes, stats.Segments)
+		}
 	}
 
-	downloadInfo := map[string]interface{}{
-		"url":          url,
-		"output_path":  *outputPath,
-		"size":         fileInfo.Size(),
-		"duration":     fileInfo.Size() / 1024 / 1024,
-		"started_at":   time.Now(),
-		"completed_at": time.Now(),
+	stats, err := hls.Download(ctx, url, file, hlsOptions)
+	result.CompletedAt = time.Now()
+	if stats != nil {
+		result.Size = stats.Bytes
+		result.Segments = stats.Segments
+		result.Duration = stats.Duration
+		result.Split = stats.Limited
+		if stats.Discontinuities > 0 || stats.Skipped > 0 || stats.Duplicates > 0 {
+			logrus.Infof("HLS download of %s: %d discontinuities, %d skipped and %d duplicate segments",
+				*outputPath, stats.Discontinuities, stats.Skipped, stats.Duplicates)
+		}
+	}
+
+	// Cancelling is a normal way to stop, as long as something was captured
+	if err != nil && !(ctx.Err() != nil && result.Segments > 0) {
+		logrus.Errorf("Failed to download HLS natively: %v", err)
+		result.Err = err
+		return result
+	}
+	if err != nil {
+		logrus.Infof("Recording stopped: %v", ctx.Err())
 	}
+	result.ExitCode = 0
 
-	return downloadInfo
+	return result
 }
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+)
+
+// ffmpegLogLimit is how much of the ffmpeg stderr is kept for error messages
+const ffmpegLogLimit = 16 * 1024
+
+// ffmpegLog collects ffmpeg stderr, keeping only the tail and counting fetched segments
+type ffmpegLog struct {
+	mu       sync.Mutex
+	tail     []byte
+	line     []byte
+	segments int
+}
+
+func (l *ffmpegLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tail = append(l.tail, p...)
+	if len(l.tail) > ffmpegLogLimit {
+		l.tail = l.tail[len(l.tail)-ffmpegLogLimit:]
+	}
+
+	for _, c := range p {
+		if c != '\n' && c != '\r' {
+			l.line = append(l.line, c)
+			continue
+		}
+		l.parseLine(string(l.line))
+		l.line = l.line[:0]
+	}
+	return len(p), nil
+}
+
+// parseLine counts lines like "[hls @ 0x...] Opening 'https://.../123.ts' for reading"
+func (l *ffmpegLog) parseLine(line string) {
+	if !strings.Contains(line, "Opening '") || !strings.HasSuffix(line, "for reading") {
+		return
+	}
+	if strings.Contains(line, ".m3u8") {
+		return
+	}
+	l.segments++
+}
+
+func (l *ffmpegLog) Segments() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.segments
+}
+
+func (l *ffmpegLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return string(l.tail)
+}
+
+// ffmpegProgress reads the key=value blocks ffmpeg writes with -progress and passes their totals to a meter
+type ffmpegProgress struct {
+	meter   *recorder.ProgressMeter
+	log     *ffmpegLog // Counts the segments, which the progress blocks do not report
+	line    []byte
+	elapsed time.Duration
+	size    int64
+}
+
+func (p *ffmpegProgress) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if c != '\n' && c != '\r' {
+			p.line = append(p.line, c)
+			continue
+		}
+		p.parseLine(string(p.line))
+		p.line = p.line[:0]
+	}
+	return len(b), nil
+}
+
+// parseLine reads lines like "out_time_us=1234567" and "total_size=1024", a block ends with "progress=continue"
+func (p *ffmpegProgress) parseLine(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+	switch key {
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			p.elapsed = time.Duration(us) * time.Microsecond
+		}
+	case "total_size":
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+			p.size = size
+		}
+	case "progress":
+		p.meter.Update(p.elapsed, p.size, p.log.Segments())
+	}
+}
+
+var (
+	processesMu sync.Mutex
+	processes   = make(map[*exec.Cmd]struct{})
+)
+
+// runFFmpeg runs cmd and keeps track of it while it is running, so it can be killed on a forced shutdown
+func runFFmpeg(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	processesMu.Lock()
+	processes[cmd] = struct{}{}
+	processesMu.Unlock()
+
+	err := cmd.Wait()
+
+	processesMu.Lock()
+	delete(processes, cmd)
+	processesMu.Unlock()
+	return err
+}
+
+// KillFFmpeg kills every running ffmpeg process started by this package and returns how many were killed.
+// Their outputs are left as they are, unfinalized MP4 files may be unplayable.
+func KillFFmpeg() int {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	killed := 0
+	for cmd := range processes {
+		if cmd.Process != nil && cmd.Process.Kill() == nil {
+			killed++
+		}
+	}
+	return killed
+}
+
+// ProbeDuration reads the media duration of a file using ffprobe
+func ProbeDuration(ctx context.Context, path string) (time.Duration, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(string(bytes.TrimSpace(output)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
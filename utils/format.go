@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+)
+
+// FormatExt returns the file extension of an output format
+func FormatExt(format string) string {
+	switch format {
+	case recorder.FormatTS:
+		return ".ts"
+	case recorder.FormatMKV:
+		return ".mkv"
+	default:
+		return ".mp4"
+	}
+}
+
+// FormatFromExt returns the output format matching the extension of path, MP4 for unknown extensions
+func FormatFromExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ts":
+		return recorder.FormatTS
+	case ".mkv":
+		return recorder.FormatMKV
+	default:
+		return recorder.FormatMP4
+	}
+}
+
+// ValidFormat reports whether format is a known output format
+func ValidFormat(format string) bool {
+	switch format {
+	case recorder.FormatMP4, recorder.FormatFMP4, recorder.FormatTS, recorder.FormatMKV:
+		return true
+	}
+	return false
+}
+
+// formatArgs returns the ffmpeg output options writing format in a way that stays readable if ffmpeg dies
+func formatArgs(format string) []string {
+	switch format {
+	case recorder.FormatTS:
+		return []string{"-f", "mpegts"}
+	case recorder.FormatMKV:
+		return []string{"-f", "matroska"}
+	default:
+		return []string{"-bsf:a", "aac_adtstoasc", "-movflags", "frag_keyframe+empty_moov+default_base_moof", "-f", "mp4"}
+	}
+}
+
+// RemuxToMP4 remuxes path into a regular MP4 next to it and removes the original, writing metadata when not nil.
+// It returns the path of the MP4, a path already ending in .mp4 is remuxed in place.
+func RemuxToMP4(ctx context.Context, path string, metadata *MediaMetadata) (string, error) {
+	ext := filepath.Ext(path)
+	outputPath := strings.TrimSuffix(path, ext) + ".remux.mp4"
+	if ext != ".mp4" {
+		var err error
+		if outputPath, err = ReservePath(strings.TrimSuffix(path, ext) + ".mp4"); err != nil {
+			return path, fmt.Errorf("failed to remux %s to mp4: %w", path, err)
+		}
+	}
+
+	if err := JoinFilesWithMetadata(ctx, []string{path}, outputPath, metadata); err != nil {
+		os.Remove(outputPath)
+		return path, fmt.Errorf("failed to remux %s to mp4: %w", path, err)
+	}
+	if ext == ".mp4" {
+		return path, os.Rename(outputPath, path)
+	}
+	os.Remove(path)
+	return outputPath, nil
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/agilistikmal/live-recorder/pkg/recorder"
+	"github.com/sirupsen/logrus"
+)
+
+// coverTimeout bounds fetching the cover art, a missing cover never fails a recording
+const coverTimeout = 30 * time.Second
+
+// MediaMetadata is what the final mux writes into the container of an output file
+type MediaMetadata struct {
+	// Tags are ffmpeg metadata keys, keys a container has no place for are dropped by ffmpeg
+	Tags map[string]string
+	// CoverUrl is an image embedded as cover art in MP4 and Matroska files, fetched when the file is muxed
+	CoverUrl string
+}
+
+// LiveMetadata returns the tags describing live, so media libraries can show the recording
+func LiveMetadata(live *recorder.Live) *MediaMetadata {
+	if live == nil {
+		return nil
+	}
+
+	tags := map[string]string{
+		"title":    live.Title,
+		"network":  live.Platform, // Shown as the TV network of an MP4
+		"platform": live.Platform,
+		"comment":  live.PlatformUrl,
+		"url":      live.PlatformUrl,
+	}
+	if live.Streamer != nil {
+		name := live.Streamer.Name
+		if name == "" {
+			name = live.Streamer.Username
+		}
+		tags["artist"] = name
+		tags["album_artist"] = live.Streamer.Username
+		tags["service_provider"] = name // Provider of an MPEG-TS service, whose name is the title
+	}
+	if live.StartedAt != nil {
+		tags["date"] = live.StartedAt.Format("2006-01-02")
+		tags["creation_time"] = live.StartedAt.UTC().Format(time.RFC3339)
+	}
+	for key, value := range tags {
+		if value == "" {
+			delete(tags, key)
+		}
+	}
+
+	metadata := &MediaMetadata{Tags: tags, CoverUrl: live.ImageUrl}
+	if metadata.CoverUrl == "" && live.Streamer != nil {
+		metadata.CoverUrl = live.Streamer.ImageUrl
+	}
+	return metadata
+}
+
+// hasCover reports whether m asks for cover art
+func (m *MediaMetadata) hasCover() bool {
+	return m != nil && m.CoverUrl != ""
+}
+
+// tagArgs returns the ffmpeg output options writing the tags of m, in a stable order
+func (m *MediaMetadata) tagArgs() []string {
+	if m == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(m.Tags))
+	for key := range m.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+m.Tags[key])
+	}
+	return args
+}
+
+// coverArgs returns the ffmpeg options embedding the image at coverPath into an output with extension ext,
+// given as the second input. The cover is re-encoded to JPEG for MP4, which cannot hold every image format.
+func coverArgs(coverPath string, ext string) (inputs []string, outputs []string) {
+	switch ext {
+	case ".mp4":
+		return []string{"-i", coverPath},
+			[]string{"-map", "0:V?", "-map", "0:a?", "-map", "1:v", "-c:v:1", "mjpeg", "-disposition:v:1", "attached_pic"}
+	case ".mkv":
+		return nil, []string{"-attach", coverPath, "-metadata:s:t", "mimetype=" + imageMimeType(coverPath), "-metadata:s:t", "filename=cover" + filepath.Ext(coverPath)}
+	}
+	return nil, nil
+}
+
+// fetchCover downloads the image at url into a temporary file, which the caller removes
+func fetchCover(ctx context.Context, url string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, coverTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	ext := ".jpg"
+	switch strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]) {
+	case "image/png":
+		ext = ".png"
+	case "image/webp":
+		ext = ".webp"
+	}
+
+	file, err := os.CreateTemp("", "live-recorder-cover-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func imageMimeType(path string) string {
+	switch filepath.Ext(path) {
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// withCover fetches the cover of m when the container of outputPath can hold one and runs mux with it,
+// running mux again without the cover if that fails, so a bad image never costs the recording
+func withCover(ctx context.Context, m *MediaMetadata, outputPath string, mux func(coverPath string) error) error {
+	ext := filepath.Ext(outputPath)
+	if !m.hasCover() || (ext != ".mp4" && ext != ".mkv") {
+		return mux("")
+	}
+
+	coverPath, err := fetchCover(ctx, m.CoverUrl)
+	if err != nil {
+		logrus.Warnf("Failed to fetch cover art of %s: %v", outputPath, err)
+		return mux("")
+	}
+	defer os.Remove(coverPath)
+
+	if err := mux(coverPath); err != nil {
+		logrus.Warnf("Failed to embed cover art into %s, muxing without it: %v", outputPath, err)
+		return mux("")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UniquePath returns path, or path with _1, _2, ... before its extension when a file already exists there
+func UniquePath(path string) string {
+	if !exists(path) {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if !exists(candidate) {
+			return candidate
+		}
+	}
+}
+
+// CreateUnique creates a new file at path, or at path with _1, _2, ... before its extension when a file already exists there.
+// Unlike UniquePath the name is taken atomically, so writers picking names at the same time never share one.
+func CreateUnique(path string) (*os.File, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(candidate, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, os.ErrExist) {
+			return file, err
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+}
+
+// ReservePath takes a name as CreateUnique does and returns it, leaving an empty file there.
+// The caller owns the file and replaces it with the output, or removes it on failure.
+func ReservePath(path string) (string, error) {
+	file, err := CreateUnique(path)
+	if err != nil {
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -1,14 +1,31 @@
 package utils
 
 import (
+	"regexp"
 	"strings"
 
+	"github.com/agilistikmal/live-recorder/pkg/query"
 	"github.com/agilistikmal/live-recorder/pkg/recorder"
 	"github.com/sirupsen/logrus"
 )
 
-func ParseLiveQuery(query string, liveQuery *recorder.LiveQuery) error {
-	pairs := strings.Split(query, ",")
+// legacyQueryPattern matches the old key:value query syntax
+var legacyQueryPattern = regexp.MustCompile(`^\s*(title|streamer_username)\s*:`)
+
+// ParseLiveQuery parses a query into liveQuery.
+// The old syntax (title:*JKT48*,streamer_username:*_JKT48) fills the LIKE filters,
+// anything else is parsed as a filter expression (see package query).
+func ParseLiveQuery(queryString string, liveQuery *recorder.LiveQuery) error {
+	if !legacyQueryPattern.MatchString(queryString) {
+		filter, err := query.Parse(queryString)
+		if err != nil {
+			return err
+		}
+		liveQuery.Filter = filter
+		return nil
+	}
+
+	pairs := strings.Split(queryString, ",")
 
 	for _, pair := range pairs {
 		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)